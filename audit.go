@@ -0,0 +1,167 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	admission "k8s.io/api/admission/v1"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// auditReport is the result of auditing the existing ingresses against the policy
+type auditReport struct {
+	// Time is when the audit was performed
+	Time time.Time `json:"time"`
+	// Revision is the revision of the cluster policy the audit was performed against
+	Revision string `json:"revision,omitempty"`
+	// Ingresses is the number of ingresses audited
+	Ingresses int `json:"ingresses"`
+	// Violations is the list of ingresses which violate the policy
+	Violations []auditViolation `json:"violations"`
+}
+
+// auditViolation is an ingress which violates the current policy
+type auditViolation struct {
+	// Namespace is the namespace of the ingress
+	Namespace string `json:"namespace"`
+	// Name is the name of the ingress
+	Name string `json:"name"`
+	// Hosts are the hostnames on the ingress
+	Hosts []string `json:"hosts"`
+	// Reason is why the ingress violates the policy
+	Reason string `json:"reason"`
+}
+
+// runAudit periodically audits the existing ingresses once the caches have synced
+func (c *controller) runAudit(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if !c.isReady() {
+				continue
+			}
+			c.audit()
+		}
+	}
+}
+
+// audit evaluates every ingress in the cache against the current policy, reporting any which
+// violate it; the ingresses themselves are never modified
+func (c *controller) audit() *auditReport {
+	config := c.getConfig()
+	cluster := c.getClusterPolicy()
+	report := &auditReport{Time: time.Now().UTC(), Revision: cluster.Revision, Violations: []auditViolation{}}
+
+	for _, x := range c.ingresses.List() {
+		ingress, ok := x.(*networking.Ingress)
+		if !ok {
+			continue
+		}
+		report.Ingresses++
+
+		request, err := newAuditRequest(ingress)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":     err.Error(),
+				"name":      ingress.Name,
+				"namespace": ingress.Namespace,
+			}).Error("unable to encode the ingress for audit")
+
+			continue
+		}
+		result := c.evaluate(request, config, cluster)
+		if result.Reason == reasonIgnored {
+			log.WithFields(log.Fields{
+				"name":      ingress.Name,
+				"namespace": ingress.Namespace,
+			}).Debug("ignoring the policy enforcement on this namespace")
+		}
+		if !result.Allowed {
+			report.Violations = append(report.Violations, auditViolation{
+				Namespace: ingress.Namespace,
+				Name:      ingress.Name,
+				Hosts:     fromNetworkingIngress(ingress).allHosts(),
+				Reason:    result.Message,
+			})
+
+			log.WithFields(log.Fields{
+				"error":     result.Message,
+				"name":      ingress.Name,
+				"namespace": ingress.Namespace,
+			}).Warn("existing ingress violates the policy")
+
+			if c.recorder != nil {
+				c.recorder.Event(ingress, api.EventTypeWarning, eventPolicyViolation, result.Message)
+			}
+		}
+	}
+	sort.Slice(report.Violations, func(i, j int) bool {
+		a, b := report.Violations[i], report.Violations[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	c.Lock()
+	c.report = report
+	c.Unlock()
+
+	log.WithFields(log.Fields{
+		"ingresses":  report.Ingresses,
+		"violations": len(report.Violations),
+	}).Info("completed the audit of the existing ingresses")
+
+	return report
+}
+
+// getAuditReport returns the last audit report, if any
+func (c *controller) getAuditReport() *auditReport {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.report
+}
+
+// newAuditRequest creates a admission request for the existing ingress
+func newAuditRequest(ingress *networking.Ingress) (*admission.AdmissionRequest, error) {
+	content, err := json.Marshal(ingress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &admission.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: networkingV1.Group, Version: networkingV1.Version, Kind: "Ingress"},
+		Name:      ingress.Name,
+		Namespace: ingress.Namespace,
+		Object:    runtime.RawExtension{Raw: content},
+		Operation: admission.Update,
+		Resource:  metav1.GroupVersionResource{Group: networkingV1.Group, Version: networkingV1.Version, Resource: "ingresses"},
+	}, nil
+}
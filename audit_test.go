@@ -0,0 +1,107 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestAudit(t *testing.T) {
+	c := newFakeAuditController(t)
+	recorder := record.NewFakeRecorder(10)
+	c.service.recorder = recorder
+
+	report := c.service.audit()
+	assert.Equal(t, 2, report.Ingresses)
+	assert.Equal(t, []auditViolation{
+		{
+			Namespace: "test",
+			Name:      "bad",
+			Hosts:     []string{"www.example.com"},
+			Reason:    "hostname: www.example.com is not permitted by namespace policy",
+		},
+	}, report.Violations)
+	assert.Equal(t, report, c.service.getAuditReport())
+
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning PolicyViolation hostname: www.example.com is not permitted by namespace policy", <-recorder.Events)
+}
+
+func TestAuditHandler(t *testing.T) {
+	c := newFakeAuditController(t)
+	c.runTests(t, []request{
+		{
+			URI:             "/audit",
+			ExpectedCode:    http.StatusNotFound,
+			ExpectedContent: "NO AUDIT\n",
+		},
+	})
+
+	c.service.audit()
+	resp, err := http.Get(c.server.URL + "/audit")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	report := &auditReport{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(report))
+	assert.Equal(t, 2, report.Ingresses)
+	assert.Len(t, report.Violations, 1)
+}
+
+func TestRunAudit(t *testing.T) {
+	c := newFakeAuditController(t)
+	go c.service.runAudit(10 * time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return c.service.getAuditReport() != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func newFakeAuditController(t *testing.T) *fakeController {
+	good := createFakeNetworkingIngress("site.apps.example.com")
+	good.Name = "good"
+	bad := createFakeNetworkingIngress("www.example.com")
+	bad.Name = "bad"
+
+	c := newFakeController()
+	c.service.client = fake.NewSimpleClientset(
+		&api.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Annotations: map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+			},
+		},
+		good,
+		bad,
+	)
+	require.NoError(t, c.service.startInformers())
+	t.Cleanup(c.service.stop)
+	waitForSync(t, c.service)
+
+	return c
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	admission "k8s.io/api/admission/v1"
+)
+
+// auditRecord is a single admission decision written to the audit log
+type auditRecord struct {
+	// Time is when the decision was made
+	Time time.Time `json:"time"`
+	// UID is the uid of the admission review
+	UID string `json:"uid"`
+	// User is the user making the request
+	User string `json:"user"`
+	// Groups are the groups of the user
+	Groups []string `json:"groups,omitempty"`
+	// Namespace is the namespace of the ingress
+	Namespace string `json:"namespace"`
+	// Name is the name of the ingress
+	Name string `json:"name"`
+	// Operation is the operation being performed
+	Operation string `json:"operation"`
+	// Hosts are the hostnames evaluated
+	Hosts []string `json:"hosts,omitempty"`
+	// Matches is the whitelist entry which permitted each hostname
+	Matches map[string]string `json:"matches,omitempty"`
+	// Sources is the policy resource which permitted each hostname
+	Sources map[string]*policySource `json:"sources,omitempty"`
+	// Decision is allowed, denied or the enforcement mode a violation was admitted under
+	Decision string `json:"decision"`
+	// Reason is the machine readable reason for the decision
+	Reason string `json:"reason"`
+	// Message explains why the request violated the policy
+	Message string `json:"message,omitempty"`
+	// Latency is the time taken to evaluate the request in seconds
+	Latency float64 `json:"latency"`
+	// Revision is the revision of the cluster policy the request was evaluated against
+	Revision string `json:"revision,omitempty"`
+}
+
+// auditLogger writes the admission decisions as json lines
+type auditLogger struct {
+	sync.Mutex
+	// writer is where the records are written
+	writer io.Writer
+	// encoder encodes the records onto the writer
+	encoder *json.Encoder
+}
+
+// newAuditLogger creates a audit logger from the configuration, writing to stdout when the path
+// is '-' or else to a file rotated by size; no logger is returned if the audit log is disabled.
+// The file is opened up front so a path which cannot be written fails the startup, rather than
+// every decision going unrecorded
+func newAuditLogger(config *Config) (*auditLogger, error) {
+	switch config.AuditLog {
+	case "":
+		return nil, nil
+	case "-":
+		return newAuditLoggerWithWriter(os.Stdout), nil
+	}
+
+	writer := &lumberjack.Logger{
+		Filename:   config.AuditLog,
+		MaxSize:    config.AuditLogMaxSize,
+		MaxBackups: config.AuditLogMaxBackups,
+		MaxAge:     config.AuditLogMaxAge,
+		Compress:   true,
+	}
+	// @note: a empty write opens the file, creating it if required
+	if _, err := writer.Write(nil); err != nil {
+		return nil, fmt.Errorf("unable to open the audit log: %s", err)
+	}
+
+	return newAuditLoggerWithWriter(writer), nil
+}
+
+// newAuditLoggerWithWriter creates a audit logger writing to the writer
+func newAuditLoggerWithWriter(writer io.Writer) *auditLogger {
+	return &auditLogger{writer: writer, encoder: json.NewEncoder(writer)}
+}
+
+// write appends the record to the audit log
+func (a *auditLogger) write(record *auditRecord) {
+	a.Lock()
+	defer a.Unlock()
+
+	if err := a.encoder.Encode(record); err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+			"uid":   record.UID,
+		}).Error("unable to write the audit record")
+	}
+}
+
+// close closes the underlying file, if any
+func (a *auditLogger) close() error {
+	a.Lock()
+	defer a.Unlock()
+
+	if closer, ok := a.writer.(io.Closer); ok && a.writer != os.Stdout {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// writeAuditRecord records the decision on the request in the audit log, if enabled
+func (c *controller) writeAuditRecord(request *admission.AdmissionRequest, result *decision, outcome, revision string, latency time.Duration) {
+	if c.auditLog == nil {
+		return
+	}
+
+	c.auditLog.write(&auditRecord{
+		Time:      time.Now().UTC(),
+		UID:       string(request.UID),
+		User:      request.UserInfo.Username,
+		Groups:    request.UserInfo.Groups,
+		Namespace: request.Namespace,
+		Name:      request.Name,
+		Operation: string(request.Operation),
+		Hosts:     result.Hosts,
+		Matches:   result.Matches,
+		Sources:   result.Sources,
+		Decision:  outcome,
+		Reason:    result.Reason,
+		Message:   result.Message,
+		Latency:   latency.Seconds(),
+		Revision:  revision,
+	})
+}
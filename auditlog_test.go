@@ -0,0 +1,122 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewAuditLogger(t *testing.T) {
+	logger, err := newAuditLogger(&Config{})
+	require.NoError(t, err)
+	assert.Nil(t, logger)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err = newAuditLogger(&Config{AuditLog: path, AuditLogMaxSize: 1})
+	require.NoError(t, err)
+	require.NotNil(t, logger)
+	assert.FileExists(t, path)
+	logger.write(&auditRecord{UID: "test", Decision: decisionAllowed})
+	require.NoError(t, logger.close())
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"uid":"test"`)
+}
+
+func TestNewAuditLoggerBad(t *testing.T) {
+	// @note: the parent directory of the audit log is a file
+	parent := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, ioutil.WriteFile(parent, []byte("test"), 0600))
+
+	_, err := newAuditLogger(&Config{AuditLog: filepath.Join(parent, "audit.log"), AuditLogMaxSize: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to open the audit log")
+}
+
+func TestAuditLogDecisions(t *testing.T) {
+	c := newFakeController()
+	buffer := &bytes.Buffer{}
+	c.service.auditLog = newAuditLoggerWithWriter(buffer)
+	c.service.setClusterPolicy(&ClusterPolicy{Revision: "10"})
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test",
+			ResourceVersion: "1039",
+			Annotations:     map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+		},
+	}, metav1.CreateOptions{})
+
+	c.runTests(t, []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReviewV1("admission.k8s.io/v1", "site.apps.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+	})
+
+	var records []*auditRecord
+	scanner := bufio.NewScanner(buffer)
+	for scanner.Scan() {
+		record := &auditRecord{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+
+	allowed := records[0]
+	assert.Equal(t, fakeUID, allowed.UID)
+	assert.Equal(t, "admin", allowed.User)
+	assert.Equal(t, "test", allowed.Namespace)
+	assert.Equal(t, "test", allowed.Name)
+	assert.Equal(t, "CREATE", allowed.Operation)
+	assert.Equal(t, []string{"site.apps.example.com"}, allowed.Hosts)
+	assert.Equal(t, map[string]string{"site.apps.example.com": "*.apps.example.com"}, allowed.Matches)
+	assert.Equal(t, map[string]*policySource{
+		"site.apps.example.com": {Kind: "Namespace", Name: "test", Revision: "1039"},
+	}, allowed.Sources)
+	assert.Equal(t, decisionAllowed, allowed.Decision)
+	assert.Equal(t, reasonPermitted, allowed.Reason)
+	assert.Equal(t, "10", allowed.Revision)
+	assert.False(t, allowed.Time.IsZero())
+
+	rejected := records[1]
+	assert.Equal(t, decisionDenied, rejected.Decision)
+	assert.Equal(t, reasonHostname, rejected.Reason)
+	assert.Equal(t, "hostname: www.example.com is not permitted by namespace policy", rejected.Message)
+	assert.Empty(t, rejected.Matches)
+	assert.Empty(t, rejected.Sources)
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	api "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// secretCACert is the key in the secret holding the ca certificate
+	secretCACert = "ca.pem"
+	// secretCAKey is the key in the secret holding the ca private key
+	secretCAKey = "ca-key.pem"
+	// secretPreviousCA is the key in the secret holding the replaced cas, trusted until they expire
+	secretPreviousCA = "ca-previous.pem"
+	// secretTLSCert is the key in the secret holding the serving certificate
+	secretTLSCert = "tls.pem"
+	// secretTLSKey is the key in the secret holding the serving private key
+	secretTLSKey = "tls-key.pem"
+	// caValidity is the period the generated ca is valid for
+	caValidity = 10 * 365 * 24 * time.Hour
+	// certValidity is the period the generated serving certificate is valid for
+	certValidity = 365 * 24 * time.Hour
+	// certRenewBefore is the remaining validity at which a certificate is renewed
+	certRenewBefore = 30 * 24 * time.Hour
+	// certRenewInterval is the interval at which we check if the certificates need renewing
+	certRenewInterval = time.Hour
+)
+
+// keyPair is a certificate and its private key
+type keyPair struct {
+	// cert is the parsed certificate
+	cert *x509.Certificate
+	// key is the private key
+	key crypto.Signer
+	// certPEM is the pem encoded certificate
+	certPEM []byte
+	// keyPEM is the pem encoded private key
+	keyPEM []byte
+}
+
+// bootstrapCertificates ensures the secret holds a valid ca and serving certificate, generating or
+// renewing them as required, and serves the result; the secret allows the replicas to share the ca.
+// A replaced ca is kept in the secret and the ca bundle until it expires, as the other replicas
+// continue to serve certificates signed by it until they next check for renewal
+func (c *controller) bootstrapCertificates(ctx context.Context) error {
+	config := c.getConfig()
+	client := c.client.CoreV1().Secrets(config.ServiceNamespace)
+	ips, err := c.serviceIPs(ctx, config)
+	if err != nil {
+		return err
+	}
+	hosts := certificateHosts(config, ips)
+
+	// @note: we retry as another replica may have written the secret underneath us
+	for attempt := 0; attempt < 3; attempt++ {
+		secret, err := client.Get(ctx, config.CertSecret, metav1.GetOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		if kerrors.IsNotFound(err) {
+			secret = nil
+		}
+
+		ca, serving := parseSecretKeyPairs(secret)
+		var previous []byte
+		if secret != nil {
+			previous = secret.Data[secretPreviousCA]
+		}
+		if ca != nil && serving != nil && !needsRenewal(ca, serving, hosts, time.Now()) {
+			return c.useCertificates(ca, serving, previous)
+		}
+
+		// @step: generate a new ca if we do not have one or it is about to expire
+		if ca == nil || time.Until(ca.cert.NotAfter) < certValidity {
+			if ca != nil {
+				previous = append(append([]byte{}, previous...), ca.certPEM...)
+			}
+			if ca, err = generateCA(fmt.Sprintf("%s-ca", config.ServiceName)); err != nil {
+				return err
+			}
+		}
+		previous = unexpiredCertificates(previous, time.Now())
+		if serving, err = generateServingCertificate(ca, hosts); err != nil {
+			return err
+		}
+
+		data := map[string][]byte{
+			secretCACert:  ca.certPEM,
+			secretCAKey:   ca.keyPEM,
+			secretTLSCert: serving.certPEM,
+			secretTLSKey:  serving.keyPEM,
+		}
+		if len(previous) > 0 {
+			data[secretPreviousCA] = previous
+		}
+		if secret == nil {
+			_, err = client.Create(ctx, &api.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: config.CertSecret, Namespace: config.ServiceNamespace},
+				Type:       api.SecretTypeOpaque,
+				Data:       data,
+			}, metav1.CreateOptions{})
+		} else {
+			secret.Data = data
+			_, err = client.Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		if kerrors.IsAlreadyExists(err) || kerrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"expires": serving.cert.NotAfter,
+			"hosts":   hosts,
+			"secret":  config.CertSecret,
+		}).Info("generated the serving certificate")
+
+		return c.useCertificates(ca, serving, previous)
+	}
+
+	return errors.New("unable to update the certificate secret, too many conflicts")
+}
+
+// useCertificates serves the certificate, re-registering the webhook if the ca bundle has changed;
+// the bundle is the ca followed by any previous cas which have yet to expire
+func (c *controller) useCertificates(ca, serving *keyPair, previous []byte) error {
+	if err := c.certificates.update(serving.certPEM, serving.keyPEM); err != nil {
+		return err
+	}
+	bundle := append(append([]byte{}, ca.certPEM...), unexpiredCertificates(previous, time.Now())...)
+
+	c.Lock()
+	changed := !bytes.Equal(c.caBundle, bundle)
+	initial := c.caBundle == nil
+	c.caBundle = bundle
+	c.Unlock()
+
+	if changed && !initial && c.getConfig().RegisterWebhook {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		return c.registerWebhook(ctx)
+	}
+
+	return nil
+}
+
+// getCABundle returns the bootstrapped ca bundle, if any
+func (c *controller) getCABundle() []byte {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.caBundle
+}
+
+// renewCertificates periodically checks and renews the bootstrapped certificates
+func (c *controller) renewCertificates() {
+	ticker := time.NewTicker(certRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := c.bootstrapCertificates(ctx); err != nil {
+				log.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Error("unable to renew the certificates")
+			}
+			cancel()
+		}
+	}
+}
+
+// certificateHosts returns the names the serving certificate should cover, being the service
+// names, the service ips and any additional hosts
+func certificateHosts(config *Config, ips []string) []string {
+	name, namespace := config.ServiceName, config.ServiceNamespace
+
+	hosts := []string{
+		name,
+		fmt.Sprintf("%s.%s", name, namespace),
+		fmt.Sprintf("%s.%s.svc", name, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace),
+	}
+	for _, x := range append(ips, config.CertHosts...) {
+		if !containedIn(x, hosts) {
+			hosts = append(hosts, x)
+		}
+	}
+
+	return hosts
+}
+
+// serviceIPs returns the cluster ips of the service, if it exists; the service may yet to be
+// created, in which case the certificate is renewed on a later check once it has been
+func (c *controller) serviceIPs(ctx context.Context, config *Config) ([]string, error) {
+	service, err := c.client.CoreV1().Services(config.ServiceNamespace).Get(ctx, config.ServiceName, metav1.GetOptions{})
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			log.WithFields(log.Fields{
+				"name":      config.ServiceName,
+				"namespace": config.ServiceNamespace,
+			}).Warn("the service does not exist, the certificate will not include the service ip")
+
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var list []string
+	for _, x := range append([]string{service.Spec.ClusterIP}, service.Spec.ClusterIPs...) {
+		if x != "" && x != api.ClusterIPNone && !containedIn(x, list) {
+			list = append(list, x)
+		}
+	}
+
+	return list, nil
+}
+
+// parseSecretKeyPairs returns the ca and serving certificate from the secret, or nil if missing or invalid
+func parseSecretKeyPairs(secret *api.Secret) (*keyPair, *keyPair) {
+	if secret == nil {
+		return nil, nil
+	}
+	ca, err := parseKeyPair(secret.Data[secretCACert], secret.Data[secretCAKey])
+	if err != nil {
+		return nil, nil
+	}
+	serving, err := parseKeyPair(secret.Data[secretTLSCert], secret.Data[secretTLSKey])
+	if err != nil {
+		return ca, nil
+	}
+
+	return ca, serving
+}
+
+// needsRenewal checks if the serving certificate is expiring, was not signed by the ca or is
+// missing any of the hosts
+func needsRenewal(ca, serving *keyPair, hosts []string, now time.Time) bool {
+	if serving.cert.NotAfter.Sub(now) < certRenewBefore || ca.cert.NotAfter.Sub(now) < certRenewBefore {
+		return true
+	}
+	if err := serving.cert.CheckSignatureFrom(ca.cert); err != nil {
+		return true
+	}
+	for _, x := range hosts {
+		if err := serving.cert.VerifyHostname(x); err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unexpiredCertificates returns the pem encoded certificates which have yet to expire
+func unexpiredCertificates(content []byte, now time.Time) []byte {
+	var list []byte
+	for {
+		var block *pem.Block
+		if block, content = pem.Decode(content); block == nil {
+			return list
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || now.After(cert.NotAfter) {
+			continue
+		}
+		list = append(list, pem.EncodeToMemory(block)...)
+	}
+}
+
+// parseKeyPair decodes a pem encoded certificate and private key
+func parseKeyPair(certPEM, keyPEM []byte) (*keyPair, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, errors.New("no certificate found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("no private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+
+	return &keyPair{cert: cert, key: signer, certPEM: certPEM, keyPEM: keyPEM}, nil
+}
+
+// generateCA creates a self signed ca
+func generateCA(name string) (*keyPair, error) {
+	return generateKeyPair(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		NotAfter:              time.Now().Add(caValidity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil)
+}
+
+// generateServingCertificate creates a serving certificate for the hosts signed by the ca
+func generateServingCertificate(ca *keyPair, hosts []string) (*keyPair, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		NotAfter:    time.Now().Add(certValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, x := range hosts {
+		if ip := net.ParseIP(x); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, x)
+		}
+	}
+
+	return generateKeyPair(template, ca)
+}
+
+// generateKeyPair creates a key and certificate from the template, signed by the parent or self
+// signed if the parent is nil
+func generateKeyPair(template *x509.Certificate, parent *keyPair) (*keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-5 * time.Minute)
+
+	signer, signerKey := template, crypto.Signer(key)
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, key.Public(), signerKey)
+	if err != nil {
+		return nil, err
+	}
+	encodedKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encodedKey}),
+	)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCertificateHosts(t *testing.T) {
+	hosts := certificateHosts(&Config{
+		CertHosts:        []string{"10.10.22.100"},
+		ServiceName:      "ingress-admission",
+		ServiceNamespace: "kube-admission",
+	}, []string{"10.0.0.10", "10.10.22.100"})
+	assert.Equal(t, []string{
+		"ingress-admission",
+		"ingress-admission.kube-admission",
+		"ingress-admission.kube-admission.svc",
+		"ingress-admission.kube-admission.svc.cluster.local",
+		"10.0.0.10",
+		"10.10.22.100",
+	}, hosts)
+}
+
+func TestBootstrapCertificatesServiceIP(t *testing.T) {
+	c := newFakeBootstrapController()
+	_, err := c.client.CoreV1().Services("kube-admission").Create(context.TODO(), &api.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-admission", Namespace: "kube-admission"},
+		Spec: api.ServiceSpec{
+			ClusterIP:  "10.0.0.10",
+			ClusterIPs: []string{"10.0.0.10", "fd00::10"},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, c.bootstrapCertificates(context.TODO()))
+
+	status := c.certificates.status()
+	require.NotNil(t, status)
+	assert.Equal(t, []string{"10.0.0.10", "fd00::10"}, status.IPAddresses)
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	hosts := []string{"ingress-admission", "10.10.22.100"}
+	ca, err := generateCA("ca")
+	require.NoError(t, err)
+	other, err := generateCA("other")
+	require.NoError(t, err)
+	serving, err := generateServingCertificate(ca, hosts)
+	require.NoError(t, err)
+	expiring, err := generateKeyPair(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "ingress-admission"},
+		DNSNames: []string{"ingress-admission"},
+		NotAfter: time.Now().Add(24 * time.Hour),
+	}, ca)
+	require.NoError(t, err)
+
+	assert.False(t, needsRenewal(ca, serving, hosts, time.Now()))
+	assert.True(t, needsRenewal(ca, serving, append(hosts, "missing"), time.Now()))
+	assert.True(t, needsRenewal(other, serving, hosts, time.Now()))
+	assert.True(t, needsRenewal(ca, expiring, []string{"ingress-admission"}, time.Now()))
+	assert.True(t, needsRenewal(ca, serving, hosts, time.Now().Add(certValidity)))
+}
+
+func TestBootstrapCertificates(t *testing.T) {
+	c := newFakeBootstrapController()
+	require.NoError(t, c.bootstrapCertificates(context.TODO()))
+
+	secret, err := c.client.CoreV1().Secrets("kube-admission").Get(context.TODO(), "ingress-admission-tls", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, secret.Data[secretCACert], c.getCABundle())
+	status := c.certificates.status()
+	assert.Equal(t, "ingress-admission", status.Subject)
+	assert.Contains(t, status.DNSNames, "ingress-admission.kube-admission.svc")
+
+	// @step: another replica should reuse the certificates from the secret
+	replica := newFakeBootstrapController()
+	replica.client = c.client
+	require.NoError(t, replica.bootstrapCertificates(context.TODO()))
+	assert.Equal(t, c.getCABundle(), replica.getCABundle())
+	assert.Equal(t, status.NotAfter, replica.certificates.status().NotAfter)
+}
+
+func TestBootstrapCertificatesRenewal(t *testing.T) {
+	ca, err := generateCA("ca")
+	require.NoError(t, err)
+	expiring, err := generateKeyPair(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "ingress-admission"},
+		DNSNames: []string{"ingress-admission"},
+		NotAfter: time.Now().Add(24 * time.Hour),
+	}, ca)
+	require.NoError(t, err)
+
+	c := newFakeBootstrapController()
+	_, err = c.client.CoreV1().Secrets("kube-admission").Create(context.TODO(), &api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-admission-tls", Namespace: "kube-admission"},
+		Data: map[string][]byte{
+			secretCACert:  ca.certPEM,
+			secretCAKey:   ca.keyPEM,
+			secretTLSCert: expiring.certPEM,
+			secretTLSKey:  expiring.keyPEM,
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, c.bootstrapCertificates(context.TODO()))
+	assert.Equal(t, ca.certPEM, c.getCABundle())
+	assert.True(t, c.certificates.status().NotAfter.After(time.Now().Add(certRenewBefore)))
+}
+
+func TestBootstrapCertificatesRotatesCA(t *testing.T) {
+	expiring, err := generateKeyPair(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "expiring"},
+		NotAfter:              time.Now().Add(certValidity / 2),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	require.NoError(t, err)
+	expired, err := generateKeyPair(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "expired"},
+		NotBefore:             time.Now().Add(-2 * time.Hour),
+		NotAfter:              time.Now().Add(-time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	require.NoError(t, err)
+
+	c := newFakeBootstrapController()
+	_, err = c.client.CoreV1().Secrets("kube-admission").Create(context.TODO(), &api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-admission-tls", Namespace: "kube-admission"},
+		Data: map[string][]byte{
+			secretCACert:     expiring.certPEM,
+			secretCAKey:      expiring.keyPEM,
+			secretPreviousCA: expired.certPEM,
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, c.bootstrapCertificates(context.TODO()))
+
+	// @check the replaced ca is kept and trusted, while the expired ca is dropped
+	secret, err := c.client.CoreV1().Secrets("kube-admission").Get(context.TODO(), "ingress-admission-tls", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, expiring.certPEM, secret.Data[secretCACert])
+	assert.Equal(t, expiring.certPEM, secret.Data[secretPreviousCA])
+	assert.Equal(t, append(append([]byte{}, secret.Data[secretCACert]...), expiring.certPEM...), c.getCABundle())
+
+	// @step: another replica should publish the same bundle
+	replica := newFakeBootstrapController()
+	replica.client = c.client
+	require.NoError(t, replica.bootstrapCertificates(context.TODO()))
+	assert.Equal(t, c.getCABundle(), replica.getCABundle())
+}
+
+func TestBootstrapCertificatesRegistersWebhook(t *testing.T) {
+	c := newFakeBootstrapController()
+	c.config.RegisterWebhook = true
+	c.config.WebhookAPI = WebhookAPIValidating
+	c.config.WebhookFailurePolicy = "Ignore"
+	require.NoError(t, c.bootstrapCertificates(context.TODO()))
+
+	// @step: a new ca should be pushed into the webhook configuration
+	ca, err := generateCA("rotated")
+	require.NoError(t, err)
+	serving, err := generateServingCertificate(ca, certificateHosts(c.config, nil))
+	require.NoError(t, err)
+	require.NoError(t, c.useCertificates(ca, serving, nil))
+
+	webhook, err := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), AdmissionControllerName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ca.certPEM, webhook.Webhooks[0].ClientConfig.CABundle)
+}
+
+func newFakeBootstrapController() *controller {
+	c := newFakeController().service
+	c.certificates = &certificateReloader{}
+	c.config.CertSecret = "ingress-admission-tls"
+	c.config.ServiceName = "ingress-admission"
+	c.config.ServiceNamespace = "kube-admission"
+
+	return c
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// startInformers is responsible for starting the shared informers and marking
+// the controller as ready once the caches have synced
+func (c *controller) startInformers() error {
+	factory := informers.NewSharedInformerFactory(c.client, 0)
+
+	namespaces := factory.Core().V1().Namespaces()
+	c.namespaces = namespaces.Lister()
+	if _, err := namespaces.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if namespace, ok := obj.(*api.Namespace); ok {
+				c.whitelists.delete(namespace.Name)
+			}
+		},
+	}); err != nil {
+		return err
+	}
+
+	ingresses := factory.Networking().V1().Ingresses().Informer()
+	if err := ingresses.AddIndexers(cache.Indexers{ingressHostIndex: indexIngressByHost}); err != nil {
+		return err
+	}
+	c.ingresses = ingresses.GetIndexer()
+
+	synced := []cache.InformerSynced{namespaces.Informer().HasSynced, ingresses.HasSynced}
+
+	factory.Start(c.stopCh)
+
+	// @step: start the cluster policy informer if required
+	if name := c.getConfig().PolicyConfigMap; name != "" {
+		namespace, name, err := cache.SplitMetaNamespaceKey(name)
+		if err != nil {
+			return err
+		}
+		policy := informers.NewSharedInformerFactoryWithOptions(c.client, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+			}),
+		)
+		configmaps := policy.Core().V1().ConfigMaps().Informer()
+		if _, err := configmaps.AddEventHandler(c.clusterPolicyHandler()); err != nil {
+			return err
+		}
+		synced = append(synced, configmaps.HasSynced)
+
+		policy.Start(c.stopCh)
+	}
+
+	// @step: start the domain policy informer if required
+	if c.dynamic != nil && c.getConfig().EnablePolicyCRD {
+		policies := dynamicinformer.NewDynamicSharedInformerFactory(c.dynamic, 0).
+			ForResource(domainPolicyResource).Informer()
+		if err := policies.SetTransform(toDomainPolicy); err != nil {
+			return err
+		}
+		c.policies = policies.GetStore()
+		synced = append(synced, policies.HasSynced)
+
+		go policies.Run(c.stopCh)
+	}
+
+	go func() {
+		if !cache.WaitForCacheSync(c.stopCh, synced...) {
+			log.Error("unable to sync the informer caches")
+			return
+		}
+		atomic.StoreInt32(&c.synced, 1)
+
+		log.Info("informer caches have synced, controller is ready")
+	}()
+
+	return nil
+}
+
+// indexIngressByHost indexes the ingresses by the hostnames in their rules; only the networking/v1
+// ingresses are watched, which relies on the apiserver serving every ingress regardless of the
+// version it was created under (i.e. extensions/v1beta1) via networking/v1. Only the rule hosts
+// are claims; the tls hosts are not indexed, and a wildcard rule host (i.e. *.apps.example.com)
+// is indexed literally, so it only collides with the same wildcard, never the hosts beneath it
+func indexIngressByHost(obj interface{}) ([]string, error) {
+	ingress, ok := obj.(*networking.Ingress)
+	if !ok {
+		return nil, nil
+	}
+
+	return fromNetworkingIngress(ingress).hosts(), nil
+}
+
+// waitForCaches waits for the informer caches to sync, or the timeout to expire
+func (c *controller) waitForCaches(timeout time.Duration) error {
+	return wait.PollUntilContextTimeout(context.Background(), 100*time.Millisecond, timeout, true,
+		func(context.Context) (bool, error) { return c.isReady(), nil })
+}
+
+// isReady checks if the informer caches have synced
+func (c *controller) isReady() bool {
+	return atomic.LoadInt32(&c.synced) == 1
+}
+
+// getNamespace retrieves the namespace from the cache, falling back to the api
+// when the namespace has yet to make it into the cache
+func (c *controller) getNamespace(name string) (*api.Namespace, error) {
+	if c.namespaces != nil {
+		namespace, err := c.namespaces.Get(name)
+		if err == nil {
+			return namespace, nil
+		}
+		if !kerrors.IsNotFound(err) {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"namespace": name,
+		}).Debug("namespace not found in cache, falling back to the api")
+	}
+
+	namespace, err := c.client.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		c.metrics.recordLookupFailure("namespaces")
+
+		return nil, err
+	}
+
+	return namespace, nil
+}
+
+// getCachedNamespace retrieves the namespace from the cache only, never calling the api
+func (c *controller) getCachedNamespace(name string) (*api.Namespace, error) {
+	if c.namespaces == nil {
+		return nil, errors.New("the namespace cache is not available")
+	}
+
+	return c.namespaces.Get(name)
+}
+
+// hostOwner returns the namespace of any ingress outside the namespace which has already
+// claimed the hostname; hosts permitted to be shared are never considered claimed
+func (c *controller) hostOwner(hostname, namespace string, config *Config) (string, bool, error) {
+	if c.ingresses == nil || hasDomain(hostname, config.SharedHosts) {
+		return "", false, nil
+	}
+
+	items, err := c.ingresses.ByIndex(ingressHostIndex, strings.ToLower(hostname))
+	if err != nil {
+		return "", false, err
+	}
+	for _, x := range items {
+		if ingress, ok := x.(*networking.Ingress); ok && ingress.Namespace != namespace {
+			return ingress.Namespace, true, nil
+		}
+	}
+
+	return "", false, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestReadyHandlerNotSynced(t *testing.T) {
+	requests := []request{
+		{
+			URI:          "/ready",
+			ExpectedCode: http.StatusServiceUnavailable,
+		},
+	}
+	newFakeController().runTests(t, requests)
+}
+
+func TestReadyHandlerSynced(t *testing.T) {
+	c := newFakeController()
+	require.NoError(t, c.service.startInformers())
+	defer c.service.stop()
+	waitForSync(t, c.service)
+
+	requests := []request{
+		{
+			URI:             "/ready",
+			ExpectedCode:    http.StatusOK,
+			ExpectedContent: "OK\n",
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestGetNamespaceFromCache(t *testing.T) {
+	c := newFakeController()
+	c.service.client = fake.NewSimpleClientset(&api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.test.svc.cluster.local"},
+		},
+	})
+	require.NoError(t, c.service.startInformers())
+	defer c.service.stop()
+	waitForSync(t, c.service)
+
+	namespace, err := c.service.namespaces.Get("test")
+	require.NoError(t, err)
+	assert.Equal(t, "test", namespace.Name)
+
+	namespace, err = c.service.getNamespace("test")
+	require.NoError(t, err)
+	require.NotNil(t, namespace)
+	assert.Equal(t, "*.test.svc.cluster.local", namespace.GetAnnotations()[DomainWhitelistAnnotation])
+}
+
+func TestGetNamespaceFallback(t *testing.T) {
+	c := newFakeController()
+	c.service.client = fake.NewSimpleClientset(&api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "missing"}})
+	// @note: an empty cache, i.e. the namespace has not been seen by the informer yet
+	c.service.namespaces = corelisters.NewNamespaceLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+
+	namespace, err := c.service.getNamespace("missing")
+	require.NoError(t, err)
+	require.NotNil(t, namespace)
+	assert.Equal(t, "missing", namespace.Name)
+
+	_, err = c.service.getNamespace("none")
+	assert.Error(t, err)
+}
+
+func waitForSync(t *testing.T, c *controller) {
+	for i := 0; i < 100; i++ {
+		if c.isReady() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("informer caches failed to sync")
+}
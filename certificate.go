@@ -0,0 +1,154 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certificateReloader serves the certificate from the files, reloading it when they change, or
+// from memory when the certificates are bootstrapped
+type certificateReloader struct {
+	sync.RWMutex
+	// certFile is the path to the certificate
+	certFile string
+	// keyFile is the path to the private key
+	keyFile string
+	// certificate is the current certificate
+	certificate *tls.Certificate
+}
+
+// certificateStatus describes the certificate being served
+type certificateStatus struct {
+	// Subject is the common name of the certificate
+	Subject string `json:"subject"`
+	// DNSNames are the subject alternative names on the certificate
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// IPAddresses are the ip addresses on the certificate
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+	// NotBefore is the time the certificate is valid from
+	NotBefore time.Time `json:"notBefore"`
+	// NotAfter is the time the certificate expires
+	NotAfter time.Time `json:"notAfter"`
+	// ExpiresIn is the number of seconds until the certificate expires
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// newCertificateReloader creates a reloader and loads the initial certificate
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// load reads the certificate from the files, replacing the current one only if it is valid
+func (r *certificateReloader) load() error {
+	certPEM, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	return r.update(certPEM, keyPEM)
+}
+
+// update replaces the current certificate with the pem encoded pair, if valid
+func (r *certificateReloader) update(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.certificate = &cert
+
+	return nil
+}
+
+// watch reloads the certificate whenever the files change; a certificate which fails to load is
+// logged and the current one retained
+func (r *certificateReloader) watch(stopCh <-chan struct{}) error {
+	return watchFiles([]string{r.certFile, r.keyFile}, stopCh, func() {
+		if err := r.load(); err != nil {
+			log.WithFields(log.Fields{
+				"cert":  r.certFile,
+				"error": err.Error(),
+				"key":   r.keyFile,
+			}).Error("unable to reload the certificate, retaining the current certificate")
+
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"expires": r.status().NotAfter,
+		}).Info("reloaded the tls certificate")
+	})
+}
+
+// GetCertificate returns the current certificate for the tls handshake
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.certificate == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+
+	return r.certificate, nil
+}
+
+// status returns a description of the current certificate, or nil if none has been loaded
+func (r *certificateReloader) status() *certificateStatus {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.certificate == nil {
+		return nil
+	}
+	leaf := r.certificate.Leaf
+	status := &certificateStatus{
+		Subject:   leaf.Subject.CommonName,
+		DNSNames:  leaf.DNSNames,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		ExpiresIn: int64(time.Until(leaf.NotAfter).Seconds()),
+	}
+	for _, x := range leaf.IPAddresses {
+		status.IPAddresses = append(status.IPAddresses, x.String())
+	}
+
+	return status
+}
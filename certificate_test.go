@@ -0,0 +1,86 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := createFakeCertificate(t, "ca", nil)
+	createFakeCertificate(t, "first.example.com", ca).write(t, dir, "tls")
+
+	r, err := newCertificateReloader(filepath.Join(dir, "tls.pem"), filepath.Join(dir, "tls-key.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, "first.example.com", r.status().Subject)
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.NotNil(t, cert)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	require.NoError(t, r.watch(stopCh))
+
+	// @step: a bad certificate should be ignored
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.pem"), []byte("bad"), 0644))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "first.example.com", r.status().Subject)
+
+	// @step: a rotated certificate should be served
+	createFakeCertificate(t, "second.example.com", ca).write(t, dir, "tls")
+	assert.Eventually(t, func() bool {
+		return r.status().Subject == "second.example.com"
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestCertificateReloaderBad(t *testing.T) {
+	_, err := newCertificateReloader("/does/not/exist.pem", "/does/not/exist-key.pem")
+	assert.Error(t, err)
+}
+
+func TestStatusHandler(t *testing.T) {
+	dir := t.TempDir()
+	createFakeCertificate(t, "ingress-admission", createFakeCertificate(t, "ca", nil)).write(t, dir, "tls")
+
+	c := newFakeController()
+	certificates, err := newCertificateReloader(filepath.Join(dir, "tls.pem"), filepath.Join(dir, "tls-key.pem"))
+	require.NoError(t, err)
+	c.service.certificates = certificates
+
+	resp, err := http.Get(c.server.URL + "/status")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	status := &controllerStatus{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(status))
+	assert.False(t, status.Ready)
+	assert.Equal(t, Version, status.Version)
+	require.NotNil(t, status.Certificate)
+	assert.Equal(t, "ingress-admission", status.Certificate.Subject)
+	assert.Equal(t, []string{"ingress-admission"}, status.Certificate.DNSNames)
+	assert.True(t, status.Certificate.ExpiresIn > 0)
+}
@@ -0,0 +1,445 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	admission "k8s.io/api/admission/v1"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// checkExitViolations is the exit code when any of the ingresses violate the policy
+	checkExitViolations = 1
+	// checkExitError is the exit code when the check could not be performed
+	checkExitError = 2
+)
+
+// manifest is a single ingress read from the inputs
+type manifest struct {
+	// File is where the manifest was read from
+	File string
+	// Object is the decoded manifest
+	Object *unstructured.Unstructured
+}
+
+// checkResult is the outcome of checking a ingress against the policy
+type checkResult struct {
+	// File is where the ingress was read from
+	File string `json:"file"`
+	// Namespace is the namespace the ingress was evaluated in
+	Namespace string `json:"namespace"`
+	// Name is the name of the ingress
+	Name string `json:"name"`
+	// Allowed indicates the ingress is permitted by the policy
+	Allowed bool `json:"allowed"`
+	// Hosts are the hostnames on the ingress
+	Hosts []string `json:"hosts,omitempty"`
+	// Reason is the machine readable reason for the decision
+	Reason string `json:"reason"`
+	// Message explains why the ingress violates the policy
+	Message string `json:"message,omitempty"`
+}
+
+// checkReport is the result of checking all the ingresses
+type checkReport struct {
+	// Results is the outcome for each of the ingresses
+	Results []checkResult `json:"results"`
+	// Violations is the number of ingresses which violate the policy
+	Violations int `json:"violations"`
+}
+
+// checkCommand returns the command used to check ingress manifests against the policy without a cluster
+func checkCommand() cli.Command {
+	return cli.Command{
+		Name:      "check",
+		Usage:     "checks the ingress manifests against the policy without a cluster, exiting non-zero on any violation",
+		ArgsUsage: "FILE|DIRECTORY|- ...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "namespace",
+				Usage: "the namespace for any manifests which do not specify one `NAMESPACE`",
+				Value: "default",
+			},
+			cli.StringFlag{
+				Name:  "whitelist",
+				Usage: "the value of the namespace whitelist annotation `DOMAINS`",
+			},
+			cli.BoolFlag{
+				Name:  "allow-catch-all",
+				Usage: "permit the namespace catch-all ingresses, as the catch-all annotation `BOOL`",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Usage: "a label on the namespaces, used by the domain policy selectors `KEY=VALUE`",
+			},
+			cli.StringSliceFlag{
+				Name:  "policy",
+				Usage: "the path to the cluster policy (the policy itself or the configmap holding it) or domain policy manifests `PATH`",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "the output format, text or json `FORMAT`",
+				Value: "text",
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			log.SetLevel(log.WarnLevel)
+
+			output := ctx.String("output")
+			if output != "text" && output != "json" {
+				return cli.NewExitError(fmt.Sprintf("[error] unknown output format: %s", output), checkExitError)
+			}
+			if !ctx.Args().Present() {
+				return cli.NewExitError("[error] no manifests given", checkExitError)
+			}
+			config, err := loadConfig(ctx.GlobalString("config"), ctx.Parent())
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] invalid configuration, %s", err), checkExitError)
+			}
+			policy, domains, err := readPolicies(ctx.StringSlice("policy"))
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] invalid policy, %s", err), checkExitError)
+			}
+			namespace := &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ctx.String("namespace")}}
+			if namespace.Labels, err = labels.ConvertSelectorToLabelsMap(strings.Join(ctx.StringSlice("label"), ",")); err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] invalid label, %s", err), checkExitError)
+			}
+			if whitelist := ctx.String("whitelist"); whitelist != "" {
+				namespace.Annotations = map[string]string{DomainWhitelistAnnotation: whitelist}
+			}
+			if ctx.Bool("allow-catch-all") {
+				if namespace.Annotations == nil {
+					namespace.Annotations = map[string]string{}
+				}
+				namespace.Annotations[CatchAllAnnotation] = "true"
+			}
+			manifests, err := readManifests(ctx.Args(), os.Stdin)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] %s", err), checkExitError)
+			}
+
+			report, err := check(config, policy, domains, manifests, namespace)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] %s", err), checkExitError)
+			}
+			if output == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(report)
+			} else {
+				err = report.write(os.Stdout)
+			}
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] %s", err), checkExitError)
+			}
+			if report.Violations > 0 {
+				return cli.NewExitError("", checkExitViolations)
+			}
+
+			return nil
+		},
+	}
+}
+
+// check evaluates the manifests against the cluster and domain policies, with every namespace given
+// the labels and annotations of the template, the name of which is the default namespace; the
+// evaluation is the same as the admission controller, minus the hostname ownership which
+// requires the ingresses in the cluster
+func check(config *Config, policy *ClusterPolicy, domains []*IngressDomainPolicy, manifests []manifest, template *api.Namespace) (*checkReport, error) {
+	ctl, err := newController(*config)
+	if err != nil {
+		return nil, err
+	}
+	ctl.setClusterPolicy(policy)
+
+	// @step: load the domain policies as the informer would
+	ctl.policies = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, x := range domains {
+		if err := ctl.policies.Add(x); err != nil {
+			return nil, err
+		}
+	}
+
+	// @step: create the namespaces the ingresses live in, as they would be labelled and annotated
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	ctl.namespaces = corelisters.NewNamespaceLister(namespaces)
+
+	report := &checkReport{Results: []checkResult{}}
+	for _, x := range manifests {
+		if x.Object.GetNamespace() == "" {
+			x.Object.SetNamespace(template.Name)
+		}
+		if _, found, _ := namespaces.GetByKey(x.Object.GetNamespace()); !found {
+			namespace := template.DeepCopy()
+			namespace.Name = x.Object.GetNamespace()
+			if err := namespaces.Add(namespace); err != nil {
+				return nil, err
+			}
+		}
+
+		result := checkResult{File: x.File, Namespace: x.Object.GetNamespace(), Name: x.Object.GetName()}
+		request, err := newCheckRequest(x.Object)
+		if err != nil {
+			result.Reason = reasonInvalidObject
+			result.Message = err.Error()
+		} else {
+			evaluated := ctl.evaluate(request, config, policy)
+			result.Allowed = evaluated.Allowed
+			result.Hosts = evaluated.Hosts
+			result.Reason = evaluated.Reason
+			result.Message = evaluated.Message
+		}
+		if !result.Allowed {
+			report.Violations++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	return report, nil
+}
+
+// write prints the report in a human readable form
+func (r *checkReport) write(w io.Writer) error {
+	for _, x := range r.Results {
+		status := "PASS"
+		if !x.Allowed {
+			status = "FAIL"
+		}
+		line := fmt.Sprintf("%s  %s/%s (%s)", status, x.Namespace, x.Name, x.File)
+		if !x.Allowed {
+			line = fmt.Sprintf("%s: %s", line, x.Message)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d ingresses checked, %d violations\n", len(r.Results), r.Violations)
+
+	return err
+}
+
+// newCheckRequest creates a admission request for the ingress manifest
+func newCheckRequest(object *unstructured.Unstructured) (*admission.AdmissionRequest, error) {
+	gv, err := schema.ParseGroupVersion(object.GetAPIVersion())
+	if err != nil {
+		return nil, err
+	}
+	content, err := object.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return &admission.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: gv.Group, Version: gv.Version, Kind: object.GetKind()},
+		Name:      object.GetName(),
+		Namespace: object.GetNamespace(),
+		Object:    runtime.RawExtension{Raw: content},
+		Operation: admission.Create,
+	}, nil
+}
+
+// readPolicies reads the cluster policy and domain policies from the files; a file is either the
+// cluster policy itself or manifests holding the configmap and/or the domain policies
+func readPolicies(paths []string) (*ClusterPolicy, []*IngressDomainPolicy, error) {
+	var cluster *ClusterPolicy
+	var domains []*IngressDomainPolicy
+
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		policy, list, err := decodePolicies(content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s, %s", path, err)
+		}
+		if policy != nil {
+			if cluster != nil {
+				return nil, nil, fmt.Errorf("%s, only one cluster policy can be given", path)
+			}
+			cluster = policy
+		}
+		domains = append(domains, list...)
+	}
+	if cluster == nil {
+		cluster = &ClusterPolicy{}
+	}
+
+	return cluster, domains, nil
+}
+
+// decodePolicies decodes the cluster policy (if any) and the domain policies from the content,
+// falling back to the content being the cluster policy itself when it holds no manifests
+func decodePolicies(content []byte) (*ClusterPolicy, []*IngressDomainPolicy, error) {
+	var cluster *ClusterPolicy
+	var domains []*IngressDomainPolicy
+
+	found := false
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	for {
+		object := &unstructured.Unstructured{}
+		if err := decoder.Decode(&object.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+
+		switch object.GetKind() {
+		case "ConfigMap":
+			configmap := &api.ConfigMap{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, configmap); err != nil {
+				return nil, nil, err
+			}
+			if cluster != nil {
+				return nil, nil, errors.New("only one cluster policy can be given")
+			}
+			policy, err := parseClusterPolicy(configmap)
+			if err != nil {
+				return nil, nil, err
+			}
+			cluster = policy
+		case "IngressDomainPolicy":
+			policy, err := toDomainPolicy(object)
+			if err != nil {
+				return nil, nil, err
+			}
+			domains = append(domains, policy.(*IngressDomainPolicy))
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		policy, err := decodeClusterPolicy(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		cluster = policy
+	}
+
+	return cluster, domains, nil
+}
+
+// readManifests reads the ingresses from the files, directories or stdin when the path is '-';
+// the files may contain multiple yaml documents or lists, anything other than an ingress is ignored
+func readManifests(paths []string, stdin io.Reader) ([]manifest, error) {
+	var list []manifest
+	for _, path := range paths {
+		if path == "-" {
+			items, err := decodeManifests("stdin", stdin)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, items...)
+
+			continue
+		}
+
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			// @check only files with a manifest extension are read from a directory
+			if name != path {
+				switch strings.ToLower(filepath.Ext(name)) {
+				case ".yaml", ".yml", ".json":
+				default:
+					return nil
+				}
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			items, err := decodeManifests(name, file)
+			if err != nil {
+				return err
+			}
+			list = append(list, items...)
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// decodeManifests decodes the ingresses from the yaml or json documents in the reader
+func decodeManifests(name string, reader io.Reader) ([]manifest, error) {
+	var list []manifest
+
+	decoder := yaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		object := &unstructured.Unstructured{}
+		if err := decoder.Decode(&object.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return list, nil
+			}
+			return nil, fmt.Errorf("unable to decode: %s, %s", name, err)
+		}
+		if len(object.Object) == 0 {
+			continue
+		}
+
+		// @step: expand any lists of resources
+		items := []*unstructured.Unstructured{object}
+		if object.IsList() {
+			expanded, err := object.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode: %s, %s", name, err)
+			}
+			items = nil
+			for i := range expanded.Items {
+				items = append(items, &expanded.Items[i])
+			}
+		}
+		for _, x := range items {
+			if x.GetKind() == "Ingress" {
+				list = append(list, manifest{File: name, Object: x})
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const fakeManifests = `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: site
+spec:
+  rules:
+  - host: site.apps.example.com
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: site
+---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: www
+  namespace: web
+spec:
+  rules:
+  - host: www.example.com
+`
+
+const fakeListManifest = `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {
+      "apiVersion": "networking.k8s.io/v1",
+      "kind": "Ingress",
+      "metadata": {"name": "api"},
+      "spec": {"rules": [{"host": "api.internal.example.com"}]}
+    }
+  ]
+}`
+
+func TestReadManifests(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ingress.yml"), []byte(fakeManifests), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "nested", "list.json"), []byte(fakeListManifest), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# not a manifest"), 0644))
+
+	manifests, err := readManifests([]string{dir, "-"}, strings.NewReader(fakeManifests))
+	require.NoError(t, err)
+	require.Len(t, manifests, 5)
+
+	var names []string
+	for _, x := range manifests {
+		names = append(names, x.Object.GetName())
+	}
+	assert.Equal(t, []string{"site", "www", "api", "site", "www"}, names)
+	assert.Equal(t, filepath.Join(dir, "ingress.yml"), manifests[0].File)
+	assert.Equal(t, "stdin", manifests[4].File)
+}
+
+func TestReadManifestsBad(t *testing.T) {
+	_, err := readManifests([]string{"-"}, strings.NewReader("kind: [Ingress"))
+	assert.Error(t, err)
+
+	_, err = readManifests([]string{filepath.Join(t.TempDir(), "missing.yml")}, nil)
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	manifests, err := readManifests([]string{"-", "-"}, strings.NewReader(fakeManifests+"---\n"+fakeListManifest))
+	require.NoError(t, err)
+
+	config := &Config{DenyDomains: []DenyDomain{{Domain: "*.internal.example.com"}}}
+	namespace := &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "apps",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+		},
+	}
+	report, err := check(config, &ClusterPolicy{}, nil, manifests, namespace)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Violations)
+	assert.Equal(t, []checkResult{
+		{
+			File:      "stdin",
+			Namespace: "apps",
+			Name:      "site",
+			Allowed:   true,
+			Hosts:     []string{"site.apps.example.com"},
+			Reason:    reasonPermitted,
+		},
+		{
+			File:      "stdin",
+			Namespace: "web",
+			Name:      "www",
+			Hosts:     []string{"www.example.com"},
+			Reason:    reasonHostname,
+			Message:   "hostname: www.example.com is not permitted by namespace policy",
+		},
+		{
+			File:      "stdin",
+			Namespace: "apps",
+			Name:      "api",
+			Hosts:     []string{"api.internal.example.com"},
+			Reason:    reasonDeniedDomain,
+			Message:   "hostname: api.internal.example.com is denied by cluster policy",
+		},
+	}, report.Results)
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, report.write(buffer))
+	assert.Equal(t, "PASS  apps/site (stdin)\n"+
+		"FAIL  web/www (stdin): hostname: www.example.com is not permitted by namespace policy\n"+
+		"FAIL  apps/api (stdin): hostname: api.internal.example.com is denied by cluster policy\n"+
+		"\n3 ingresses checked, 2 violations\n", buffer.String())
+}
+
+func TestCheckClusterPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("default-domains: ['.example.com']"), 0644))
+	policy, domains, err := readPolicies([]string{path})
+	require.NoError(t, err)
+	assert.Empty(t, domains)
+
+	manifests, err := readManifests([]string{"-"}, strings.NewReader(fakeManifests))
+	require.NoError(t, err)
+	report, err := check(&Config{}, policy, nil, manifests, &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Violations)
+
+	// @step: the policy can also be read from the configmap manifest
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: policy
+data:
+  policy.yml: |
+    enforcement-mode: warn
+`), 0644))
+	policy, _, err = readPolicies([]string{path})
+	require.NoError(t, err)
+	assert.Equal(t, EnforcementModeWarn, policy.EnforcementMode)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("unknown: true"), 0644))
+	_, _, err = readPolicies([]string{path})
+	assert.Error(t, err)
+
+	// @check only one cluster policy can be given
+	other := filepath.Join(dir, "other.yml")
+	require.NoError(t, ioutil.WriteFile(other, []byte("enforcement-mode: warn"), 0644))
+	_, _, err = readPolicies([]string{other, other})
+	assert.Error(t, err)
+}
+
+func TestCheckDomainPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+apiVersion: ingress-admission.acp.homeoffice.gov.uk/v1alpha1
+kind: IngressDomainPolicy
+metadata:
+  name: apps
+spec:
+  namespaceSelector:
+    matchLabels:
+      team: apps
+  domains: ["*.apps.example.com"]
+---
+apiVersion: ingress-admission.acp.homeoffice.gov.uk/v1alpha1
+kind: IngressDomainPolicy
+metadata:
+  name: web
+spec:
+  namespaces: [web]
+  domains: ["www.example.com"]
+`), 0644))
+	policy, domains, err := readPolicies([]string{path})
+	require.NoError(t, err)
+	assert.Equal(t, &ClusterPolicy{}, policy)
+	require.Len(t, domains, 2)
+
+	manifests, err := readManifests([]string{"-"}, strings.NewReader(fakeManifests))
+	require.NoError(t, err)
+	namespace := &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "apps",
+			Labels: map[string]string{"team": "apps"},
+			// @note: the annotation should be ignored as they have been disabled
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.bank.example.com"},
+		},
+	}
+	report, err := check(&Config{DisableNamespaceAnnotations: true}, policy, domains, manifests, namespace)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Violations)
+	require.Len(t, report.Results, 2)
+	assert.True(t, report.Results[1].Allowed)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// parseClusterPolicy reads and validates the cluster policy from the configmap
+func parseClusterPolicy(configmap *api.ConfigMap) (*ClusterPolicy, error) {
+	content, found := configmap.Data[PolicyConfigMapKey]
+	if !found {
+		return nil, fmt.Errorf("configmap has no key: %s", PolicyConfigMapKey)
+	}
+
+	policy, err := decodeClusterPolicy([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	policy.Revision = configmap.ResourceVersion
+
+	return policy, nil
+}
+
+// decodeClusterPolicy decodes and validates the cluster policy
+func decodeClusterPolicy(content []byte) (*ClusterPolicy, error) {
+	policy := &ClusterPolicy{}
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, err
+	}
+	if err := policy.isValid(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// isValid checks the cluster policy is valid
+func (p *ClusterPolicy) isValid() error {
+	for _, x := range p.DenyDomains {
+		if x.Domain == "" {
+			return errors.New("deny domain has no domain")
+		}
+	}
+	if len(p.DefaultDomains) > 0 {
+		if _, err := parseWhitelistEntries(p.DefaultDomains); err != nil {
+			return fmt.Errorf("default domains are invalid: %s", err)
+		}
+	}
+	if !isEnforcementMode(p.EnforcementMode) {
+		return fmt.Errorf("invalid enforcement mode: %s", p.EnforcementMode)
+	}
+
+	return nil
+}
+
+// getClusterPolicy returns the current cluster policy
+func (c *controller) getClusterPolicy() *ClusterPolicy {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.clusterPolicy == nil {
+		return &ClusterPolicy{}
+	}
+
+	return c.clusterPolicy
+}
+
+// setClusterPolicy replaces the current cluster policy
+func (c *controller) setClusterPolicy(policy *ClusterPolicy) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.clusterPolicy = policy
+}
+
+// clusterPolicyHandler returns the event handler for the policy configmap informer; a revision
+// which fails to parse, or the configmap being deleted, is logged and the last good policy retained
+func (c *controller) clusterPolicyHandler() cache.ResourceEventHandler {
+	update := func(obj interface{}) {
+		configmap, ok := obj.(*api.ConfigMap)
+		if !ok {
+			return
+		}
+		policy, err := parseClusterPolicy(configmap)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":     err.Error(),
+				"name":      configmap.Name,
+				"namespace": configmap.Namespace,
+				"revision":  configmap.ResourceVersion,
+			}).Error("unable to parse the cluster policy, retaining the current policy")
+
+			return
+		}
+		c.setClusterPolicy(policy)
+
+		log.WithFields(log.Fields{
+			"name":      configmap.Name,
+			"namespace": configmap.Namespace,
+			"revision":  configmap.ResourceVersion,
+		}).Info("applied the cluster policy")
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj interface{}) { update(obj) },
+		DeleteFunc: func(interface{}) {
+			log.WithFields(log.Fields{
+				"revision": c.getClusterPolicy().Revision,
+			}).Error("the cluster policy configmap has been deleted, retaining the last good policy")
+		},
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const fakeClusterPolicy = `
+ignore-namespaces:
+- ignored
+deny-domains:
+- domain: "*.internal.example.com"
+default-domains:
+- "*.apps.example.com"
+`
+
+func TestParseClusterPolicy(t *testing.T) {
+	policy, err := parseClusterPolicy(createFakePolicyConfigMap(fakeClusterPolicy))
+	require.NoError(t, err)
+	assert.Equal(t, &ClusterPolicy{
+		DefaultDomains:   []string{"*.apps.example.com"},
+		DenyDomains:      []DenyDomain{{Domain: "*.internal.example.com"}},
+		IgnoreNamespaces: []string{"ignored"},
+		Revision:         "1",
+	}, policy)
+}
+
+func TestParseClusterPolicyBad(t *testing.T) {
+	cs := []string{
+		"ignore-namespaces: [",
+		"unknown: true",
+		"enforcement-mode: bad",
+		"deny-domains:\n- namespaces: [admin]",
+		"default-domains: ['re:.*']",
+	}
+	for i, x := range cs {
+		_, err := parseClusterPolicy(createFakePolicyConfigMap(x))
+		assert.Error(t, err, "case %d should have failed", i)
+	}
+
+	_, err := parseClusterPolicy(&api.ConfigMap{})
+	assert.Error(t, err)
+}
+
+func TestClusterPolicyHandler(t *testing.T) {
+	c := newFakeController()
+	handler := c.service.clusterPolicyHandler()
+
+	handler.OnAdd(createFakePolicyConfigMap(fakeClusterPolicy), false)
+	assert.Equal(t, []string{"ignored"}, c.service.getClusterPolicy().IgnoreNamespaces)
+
+	// @step: a bad revision should retain the last good policy
+	bad := createFakePolicyConfigMap("enforcement-mode: bad")
+	bad.ResourceVersion = "2"
+	handler.OnUpdate(nil, bad)
+	assert.Equal(t, "1", c.service.getClusterPolicy().Revision)
+
+	// @step: deleting the configmap should retain the last good policy
+	handler.OnDelete(bad)
+	assert.Equal(t, "1", c.service.getClusterPolicy().Revision)
+	assert.Equal(t, []string{"ignored"}, c.service.getClusterPolicy().IgnoreNamespaces)
+}
+
+func TestClusterPolicyInformer(t *testing.T) {
+	c := newFakeController()
+	c.service.config.PolicyConfigMap = "kube-admission/policy"
+	c.service.client = fake.NewSimpleClientset(createFakePolicyConfigMap(fakeClusterPolicy))
+	require.NoError(t, c.service.startInformers())
+	defer c.service.stop()
+	waitForSync(t, c.service)
+
+	assert.Equal(t, []string{"*.apps.example.com"}, c.service.getClusterPolicy().DefaultDomains)
+
+	updated := createFakePolicyConfigMap("enforcement-mode: dryrun")
+	updated.ResourceVersion = "2"
+	_, err := c.service.client.CoreV1().ConfigMaps("kube-admission").Update(context.TODO(), updated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return c.service.getClusterPolicy().EnforcementMode == EnforcementModeDryRun
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestClusterPolicyReview(t *testing.T) {
+	c := newFakeController()
+	policy, err := parseClusterPolicy(createFakePolicyConfigMap(fakeClusterPolicy))
+	require.NoError(t, err)
+	c.service.setClusterPolicy(policy)
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+	}, metav1.CreateOptions{})
+
+	ignored := createFakeIngressReview("www.example.com")
+	ignored.Spec.Namespace = "ignored"
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("site.apps.example.com"),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: ignored,
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.example.com"),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "hostname: www.example.com is not permitted by namespace policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("api.internal.example.com"),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "hostname: api.internal.example.com is denied by cluster policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestClusterPolicyDryRun(t *testing.T) {
+	c := newFakeController()
+	c.service.setClusterPolicy(&ClusterPolicy{EnforcementMode: EnforcementModeDryRun})
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+		},
+	}, metav1.CreateOptions{})
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.example.com"),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func createFakePolicyConfigMap(content string) *api.ConfigMap {
+	return &api.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "policy",
+			Namespace:       "kube-admission",
+			ResourceVersion: "1",
+		},
+		Data: map[string]string{PolicyConfigMapKey: content},
+	}
+}
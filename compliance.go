@@ -0,0 +1,290 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// complianceReport is a one-off report on the ingresses and namespace whitelists in the cluster
+type complianceReport struct {
+	// Violations are the ingresses which violate the policy
+	Violations []auditViolation `json:"violations"`
+	// UnusedEntries are the whitelist entries not used by any ingress in the namespace
+	UnusedEntries []unusedEntry `json:"unusedEntries"`
+	// NoPolicy are the namespaces which have no usable policy
+	NoPolicy []namespaceWithoutPolicy `json:"namespacesWithoutPolicy"`
+	// Collisions are the hostnames used by ingresses in more than one namespace
+	Collisions []hostCollision `json:"collisions"`
+}
+
+// unusedEntry is a whitelist entry which no ingress in the namespace uses
+type unusedEntry struct {
+	// Namespace is the namespace with the whitelist
+	Namespace string `json:"namespace"`
+	// Entry is the unused whitelist entry
+	Entry string `json:"entry"`
+	// Source is the policy resource the entry came from
+	Source string `json:"source,omitempty"`
+}
+
+// namespaceWithoutPolicy is a namespace with no usable policy, i.e. no domain policy, whitelist
+// annotation or cluster default domains
+type namespaceWithoutPolicy struct {
+	// Namespace is the name of the namespace
+	Namespace string `json:"namespace"`
+	// Ingresses is the number of ingresses in the namespace
+	Ingresses int `json:"ingresses"`
+	// Reason is why the namespace has no usable policy
+	Reason string `json:"reason"`
+}
+
+// hostCollision is a hostname used by ingresses in more than one namespace
+type hostCollision struct {
+	// Host is the hostname
+	Host string `json:"host"`
+	// Namespaces are the namespaces using the hostname
+	Namespaces []string `json:"namespaces"`
+}
+
+// auditCommand returns the command producing a compliance report of the cluster
+func auditCommand() cli.Command {
+	return cli.Command{
+		Name:  "audit",
+		Usage: "reports the policy violations, unused whitelist entries, namespaces without a policy and hostname collisions in the cluster",
+		Flags: append(kubeconfigFlags(),
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "the output format, table, json or csv `FORMAT`",
+				Value: "table",
+			},
+		),
+		Action: func(ctx *cli.Context) error {
+			output := ctx.String("output")
+			switch output {
+			case "table", "json", "csv":
+			default:
+				return cli.NewExitError(fmt.Sprintf("[error] unknown output format: %s", output), 1)
+			}
+			ctl, err := newCommandController(ctx)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] %s", err), 1)
+			}
+			defer close(ctl.stopCh)
+
+			report, err := ctl.complianceReport()
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] %s", err), 1)
+			}
+			switch output {
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(report)
+			case "csv":
+				err = report.writeCSV(os.Stdout)
+			default:
+				err = report.writeTable(os.Stdout)
+			}
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] %s", err), 1)
+			}
+
+			return nil
+		},
+	}
+}
+
+// complianceReport builds the report from the caches
+func (c *controller) complianceReport() (*complianceReport, error) {
+	config := c.getConfig()
+	cluster := c.getClusterPolicy()
+
+	report := &complianceReport{
+		Violations:    c.audit().Violations,
+		UnusedEntries: []unusedEntry{},
+		NoPolicy:      []namespaceWithoutPolicy{},
+		Collisions:    []hostCollision{},
+	}
+
+	// @step: gather the hostnames used in each namespace, and the namespaces using each rule
+	// hostname; as with the ownership check the tls hostnames are never claimed
+	hosts := make(map[string][]string)
+	owners := make(map[string]map[string]bool)
+	counts := make(map[string]int)
+	for _, x := range c.ingresses.List() {
+		ingress, ok := x.(*networking.Ingress)
+		if !ok {
+			continue
+		}
+		counts[ingress.Namespace]++
+		resource := fromNetworkingIngress(ingress)
+		hosts[ingress.Namespace] = append(hosts[ingress.Namespace], resource.allHosts()...)
+		for _, hostname := range resource.hosts() {
+			hostname = strings.ToLower(hostname)
+			if owners[hostname] == nil {
+				owners[hostname] = make(map[string]bool)
+			}
+			owners[hostname][ingress.Namespace] = true
+		}
+	}
+
+	namespaces, err := c.namespaces.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+
+	for _, namespace := range namespaces {
+		if containedIn(namespace.Name, config.IgnoreNamespaces) || containedIn(namespace.Name, cluster.IgnoreNamespaces) {
+			continue
+		}
+		policy, err := c.resolvePolicy(namespace, config, cluster)
+		if err != nil {
+			report.NoPolicy = append(report.NoPolicy, namespaceWithoutPolicy{
+				Namespace: namespace.Name,
+				Ingresses: counts[namespace.Name],
+				Reason:    err.Error(),
+			})
+
+			continue
+		}
+		report.UnusedEntries = append(report.UnusedEntries, unusedEntries(namespace.Name, policy, hosts[namespace.Name])...)
+	}
+
+	// @step: find the hostnames used across namespaces which are not permitted to be shared
+	for hostname, list := range owners {
+		if len(list) < 2 || hasDomain(hostname, config.SharedHosts) {
+			continue
+		}
+		collision := hostCollision{Host: hostname}
+		for x := range list {
+			collision.Namespaces = append(collision.Namespaces, x)
+		}
+		sort.Strings(collision.Namespaces)
+		report.Collisions = append(report.Collisions, collision)
+	}
+	sort.Slice(report.Collisions, func(i, j int) bool { return report.Collisions[i].Host < report.Collisions[j].Host })
+
+	return report, nil
+}
+
+// unusedEntries returns the entries in the namespace policy which none of the hostnames use
+func unusedEntries(namespace string, policy *namespacePolicy, hosts []string) []unusedEntry {
+	whitelist, err := parseWhitelistEntries(policy.Domains)
+	if err != nil {
+		return nil
+	}
+	unused := func(entry string) unusedEntry {
+		x := unusedEntry{Namespace: namespace, Entry: entry}
+		if source := policy.source(entry); source != nil {
+			x.Source = source.Kind + "/" + source.Name
+		}
+
+		return x
+	}
+
+	var list []unusedEntry
+	for _, entry := range whitelist.domains {
+		if entry == "" {
+			continue
+		}
+		used := false
+		for _, hostname := range hosts {
+			if hasDomain(hostname, []string{entry}) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			list = append(list, unused(entry))
+		}
+	}
+	for _, x := range whitelist.patterns {
+		used := false
+		for _, hostname := range hosts {
+			if x.re.MatchString(normalizeDomain(hostname)) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			list = append(list, unused(x.entry))
+		}
+	}
+
+	return list
+}
+
+// writeTable prints the report as tables
+func (r *complianceReport) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "VIOLATIONS (%d)\nNAMESPACE\tNAME\tHOSTS\tREASON\n", len(r.Violations))
+	for _, x := range r.Violations {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", x.Namespace, x.Name, strings.Join(x.Hosts, ","), x.Reason)
+	}
+	fmt.Fprintf(tw, "\nUNUSED WHITELIST ENTRIES (%d)\nNAMESPACE\tENTRY\tSOURCE\n", len(r.UnusedEntries))
+	for _, x := range r.UnusedEntries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", x.Namespace, x.Entry, x.Source)
+	}
+	fmt.Fprintf(tw, "\nNAMESPACES WITHOUT A POLICY (%d)\nNAMESPACE\tINGRESSES\tREASON\n", len(r.NoPolicy))
+	for _, x := range r.NoPolicy {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", x.Namespace, x.Ingresses, x.Reason)
+	}
+	fmt.Fprintf(tw, "\nHOSTNAME COLLISIONS (%d)\nHOST\tNAMESPACES\n", len(r.Collisions))
+	for _, x := range r.Collisions {
+		fmt.Fprintf(tw, "%s\t%s\n", x.Host, strings.Join(x.Namespaces, ","))
+	}
+
+	return tw.Flush()
+}
+
+// writeCSV prints the report as a single csv, with a row per finding
+func (r *complianceReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"finding", "namespace", "name", "host", "entry", "detail"})
+
+	for _, x := range r.Violations {
+		cw.Write([]string{"violation", x.Namespace, x.Name, strings.Join(x.Hosts, ","), "", x.Reason})
+	}
+	for _, x := range r.UnusedEntries {
+		cw.Write([]string{"unused-entry", x.Namespace, "", "", x.Entry, x.Source})
+	}
+	for _, x := range r.NoPolicy {
+		cw.Write([]string{"namespace-without-policy", x.Namespace, "", "", "", fmt.Sprintf("%d ingresses, %s", x.Ingresses, x.Reason)})
+	}
+	for _, x := range r.Collisions {
+		for _, namespace := range x.Namespaces {
+			cw.Write([]string{"host-collision", namespace, "", x.Host, "", fmt.Sprintf("used by: %s", strings.Join(x.Namespaces, ","))})
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
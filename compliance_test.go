@@ -0,0 +1,190 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestComplianceReport(t *testing.T) {
+	c := newFakeComplianceController(t)
+
+	report, err := c.service.complianceReport()
+	require.NoError(t, err)
+	assert.Equal(t, []auditViolation{
+		{
+			Namespace: "other",
+			Name:      "test",
+			Hosts:     []string{"www.example.com"},
+			Reason:    "namespace has no whitelist annotation: " + DomainWhitelistAnnotation,
+		},
+		{
+			Namespace: "test",
+			Name:      "bad",
+			Hosts:     []string{"www.example.com"},
+			Reason:    "hostname: www.example.com is not permitted by namespace policy",
+		},
+	}, report.Violations)
+	assert.Equal(t, []unusedEntry{
+		{Namespace: "test", Entry: "*.unused.example.com", Source: "Namespace/test"},
+		{Namespace: "test", Entry: "glob:pr-*.example.org", Source: "Namespace/test"},
+	}, report.UnusedEntries)
+	assert.Equal(t, []namespaceWithoutPolicy{
+		{Namespace: "other", Ingresses: 1, Reason: "namespace has no whitelist annotation: " + DomainWhitelistAnnotation},
+	}, report.NoPolicy)
+	assert.Equal(t, []hostCollision{
+		{Host: "www.example.com", Namespaces: []string{"other", "test"}},
+	}, report.Collisions)
+}
+
+func TestComplianceReportSharedHosts(t *testing.T) {
+	c := newFakeComplianceController(t)
+	c.service.config.SharedHosts = []string{"www.example.com"}
+
+	report, err := c.service.complianceReport()
+	require.NoError(t, err)
+	assert.Empty(t, report.Collisions)
+}
+
+func TestComplianceReportIgnoredNamespaces(t *testing.T) {
+	c := newFakeComplianceController(t)
+	c.service.config.IgnoreNamespaces = []string{"other"}
+
+	report, err := c.service.complianceReport()
+	require.NoError(t, err)
+	assert.Empty(t, report.NoPolicy)
+}
+
+func TestComplianceReportResolvedPolicy(t *testing.T) {
+	c := newFakeComplianceController(t)
+	c.service.policies = newFakePolicyStore(t, &IngressDomainPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "team"},
+		Spec:       IngressDomainPolicySpec{Namespaces: []string{"test"}, Domains: []string{"*.team.example.com"}},
+	})
+	c.service.config.PolicyConfigMap = "kube-admission/policy"
+	c.service.setClusterPolicy(&ClusterPolicy{DefaultDomains: []string{"www.example.com", "*.default.example.com"}})
+
+	// @check the namespaces given the default domains have a policy, and the unused entries cover
+	// the domain policies and the default domains
+	report, err := c.service.complianceReport()
+	require.NoError(t, err)
+	assert.Empty(t, report.NoPolicy)
+	assert.Equal(t, []unusedEntry{
+		{Namespace: "other", Entry: "*.default.example.com", Source: "ConfigMap/kube-admission/policy"},
+		{Namespace: "test", Entry: "*.team.example.com", Source: "IngressDomainPolicy/team"},
+		{Namespace: "test", Entry: "*.unused.example.com", Source: "Namespace/test"},
+		{Namespace: "test", Entry: "glob:pr-*.example.org", Source: "Namespace/test"},
+	}, report.UnusedEntries)
+}
+
+func TestComplianceReportTLSHosts(t *testing.T) {
+	c := newFakeComplianceController(t)
+	tls := createFakeNetworkingIngress("site.apps.example.com")
+	tls.Name = "tls"
+	tls.Namespace = "other"
+	tls.Spec.TLS = []networking.IngressTLS{{Hosts: []string{"www.apps.example.com"}}}
+	require.NoError(t, c.service.ingresses.Add(tls))
+	tlsOnly := tls.DeepCopy()
+	tlsOnly.Name = "tls-only"
+	tlsOnly.Namespace = "test"
+	tlsOnly.Spec.Rules[0].Host = "api.apps.example.com"
+	require.NoError(t, c.service.ingresses.Add(tlsOnly))
+
+	// @check the tls hostnames are not claimed, so never collide
+	report, err := c.service.complianceReport()
+	require.NoError(t, err)
+	assert.Equal(t, []hostCollision{
+		{Host: "site.apps.example.com", Namespaces: []string{"other", "test"}},
+		{Host: "www.example.com", Namespaces: []string{"other", "test"}},
+	}, report.Collisions)
+}
+
+func TestComplianceReportWriteTable(t *testing.T) {
+	report := &complianceReport{
+		Violations:    []auditViolation{{Namespace: "test", Name: "bad", Hosts: []string{"www.example.com"}, Reason: "denied"}},
+		UnusedEntries: []unusedEntry{{Namespace: "test", Entry: "*.unused.example.com", Source: "Namespace/test"}},
+		NoPolicy:      []namespaceWithoutPolicy{{Namespace: "other", Ingresses: 1, Reason: "no policy"}},
+		Collisions:    []hostCollision{{Host: "www.example.com", Namespaces: []string{"other", "test"}}},
+	}
+	buffer := &bytes.Buffer{}
+	require.NoError(t, report.writeTable(buffer))
+
+	output := buffer.String()
+	assert.Contains(t, output, "VIOLATIONS (1)")
+	assert.Contains(t, output, "UNUSED WHITELIST ENTRIES (1)")
+	assert.Contains(t, output, "NAMESPACES WITHOUT A POLICY (1)")
+	assert.Contains(t, output, "HOSTNAME COLLISIONS (1)")
+	assert.Contains(t, output, "*.unused.example.com")
+	assert.Contains(t, output, "other,test")
+}
+
+func TestComplianceReportWriteCSV(t *testing.T) {
+	report := &complianceReport{
+		Violations:    []auditViolation{{Namespace: "test", Name: "bad", Hosts: []string{"a.example.com", "b.example.com"}, Reason: "denied"}},
+		UnusedEntries: []unusedEntry{{Namespace: "test", Entry: "*.unused.example.com", Source: "Namespace/test"}},
+		NoPolicy:      []namespaceWithoutPolicy{{Namespace: "other", Ingresses: 1, Reason: "no policy"}},
+		Collisions:    []hostCollision{{Host: "www.example.com", Namespaces: []string{"other", "test"}}},
+	}
+	buffer := &bytes.Buffer{}
+	require.NoError(t, report.writeCSV(buffer))
+
+	expected := "finding,namespace,name,host,entry,detail\n" +
+		"violation,test,bad,\"a.example.com,b.example.com\",,denied\n" +
+		"unused-entry,test,,,*.unused.example.com,Namespace/test\n" +
+		"namespace-without-policy,other,,,,\"1 ingresses, no policy\"\n" +
+		"host-collision,other,,www.example.com,,\"used by: other,test\"\n" +
+		"host-collision,test,,www.example.com,,\"used by: other,test\"\n"
+	assert.Equal(t, expected, buffer.String())
+}
+
+func newFakeComplianceController(t *testing.T) *fakeController {
+	good := createFakeNetworkingIngress("site.apps.example.com")
+	good.Name = "good"
+	bad := createFakeNetworkingIngress("www.example.com")
+	bad.Name = "bad"
+	other := createFakeNetworkingIngress("www.example.com")
+	other.Namespace = "other"
+
+	c := newFakeController()
+	c.service.client = fake.NewSimpleClientset(
+		&api.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test",
+				Annotations: map[string]string{
+					DomainWhitelistAnnotation: "*.apps.example.com,*.unused.example.com,glob:pr-*.example.org",
+				},
+			},
+		},
+		&api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		good,
+		bad,
+		other,
+	)
+	require.NoError(t, c.service.startInformers())
+	t.Cleanup(c.service.stop)
+	waitForSync(t, c.service)
+
+	return c
+}
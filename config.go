@@ -0,0 +1,312 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/client-go/tools/cache"
+)
+
+// configReloadDelay is how long the config file must go unchanged before it is reloaded
+const configReloadDelay = 250 * time.Millisecond
+
+// loadConfig builds the configuration from the config file (if any) and the command line; flags
+// and environment variables which have been explicitly set take precedence over the file, which in
+// turn takes precedence over the flag defaults
+func loadConfig(path string, ctx *cli.Context) (*Config, error) {
+	config := &Config{}
+	set := make(map[string]interface{})
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(content, config); err != nil {
+			return nil, fmt.Errorf("unable to parse config file: %s", err)
+		}
+		if err := yaml.Unmarshal(content, &set); err != nil {
+			return nil, fmt.Errorf("unable to parse config file: %s", err)
+		}
+	}
+	if err := mergeFlags(config, ctx, set); err != nil {
+		return nil, err
+	}
+
+	return config, config.isValid()
+}
+
+// mergeFlags merges the command line options into the configuration; set are the options present
+// in the config file, so a zero value from the file is not mistaken for a option left unset
+func mergeFlags(config *Config, ctx *cli.Context, set map[string]interface{}) error {
+	stringFlags := map[string]*string{
+		"audit-log":              &config.AuditLog,
+		"cert-secret":            &config.CertSecret,
+		"context":                &config.KubeContext,
+		"enforcement-mode":       &config.EnforcementMode,
+		"kubeconfig":             &config.Kubeconfig,
+		"listen":                 &config.Listen,
+		"policy-configmap":       &config.PolicyConfigMap,
+		"service-name":           &config.ServiceName,
+		"service-namespace":      &config.ServiceNamespace,
+		"tls-ca":                 &config.TLSCA,
+		"tls-cert":               &config.TLSCert,
+		"tls-key":                &config.TLSKey,
+		"user-agent":             &config.UserAgent,
+		"webhook-api":            &config.WebhookAPI,
+		"webhook-ca-bundle":      &config.WebhookCABundle,
+		"webhook-failure-policy": &config.WebhookFailurePolicy,
+	}
+	for name, field := range stringFlags {
+		if ctx.IsSet(name) || *field == "" {
+			*field = ctx.String(name)
+		}
+	}
+
+	sliceFlags := map[string]*[]string{
+		"cert-host":        &config.CertHosts,
+		"client-name":      &config.ClientNames,
+		"ignore-namespace": &config.IgnoreNamespaces,
+		"shared-host":      &config.SharedHosts,
+	}
+	for name, field := range sliceFlags {
+		if ctx.IsSet(name) {
+			*field = ctx.StringSlice(name)
+		}
+	}
+
+	boolFlags := map[string]*bool{
+		"enable-cert-bootstrap":         &config.EnableCertBootstrap,
+		"deregister-webhook":            &config.DeregisterWebhook,
+		"disable-namespace-annotations": &config.DisableNamespaceAnnotations,
+		"enable-client-tls":             &config.EnableClientTLS,
+		"enable-http-logging":           &config.EnableLogging,
+		"enable-policy-crd":             &config.EnablePolicyCRD,
+		"register-webhook":              &config.RegisterWebhook,
+	}
+	for name, field := range boolFlags {
+		if ctx.IsSet(name) {
+			*field = ctx.Bool(name)
+		}
+	}
+
+	intFlags := map[string]*int{
+		"audit-log-max-age":     &config.AuditLogMaxAge,
+		"audit-log-max-backups": &config.AuditLogMaxBackups,
+		"audit-log-max-size":    &config.AuditLogMaxSize,
+		"client-burst":          &config.ClientBurst,
+	}
+	for name, field := range intFlags {
+		if _, found := set[name]; ctx.IsSet(name) || !found {
+			*field = ctx.Int(name)
+		}
+	}
+
+	if ctx.IsSet("audit-interval") {
+		config.AuditInterval = ctx.Duration("audit-interval")
+	}
+	if ctx.IsSet("client-qps") {
+		config.ClientQPS = ctx.Float64("client-qps")
+	}
+
+	if ctx.IsSet("deny-domain") {
+		denied, err := parseDenyDomains(ctx.StringSlice("deny-domain"))
+		if err != nil {
+			return err
+		}
+		config.DenyDomains = denied
+	}
+
+	return nil
+}
+
+// isValid checks the configuration is valid
+func (c *Config) isValid() error {
+	if c.Listen == "" {
+		return errors.New("no listen interface defined")
+	}
+	for _, x := range c.DenyDomains {
+		if x.Domain == "" {
+			return errors.New("deny domain has no domain")
+		}
+	}
+	if !isEnforcementMode(c.EnforcementMode) {
+		return fmt.Errorf("invalid enforcement mode: %s", c.EnforcementMode)
+	}
+	if c.AuditInterval < 0 {
+		return errors.New("audit interval cannot be negative")
+	}
+	if c.AuditLogMaxAge < 0 || c.AuditLogMaxBackups < 0 || c.AuditLogMaxSize < 0 {
+		return errors.New("audit log max age, backups and size cannot be negative")
+	}
+	if c.ClientQPS < 0 || c.ClientBurst < 0 {
+		return errors.New("client qps and burst cannot be negative")
+	}
+	if c.EnableClientTLS && c.TLSCA == "" {
+		return errors.New("mutual tls requires a ca")
+	}
+	if c.EnableCertBootstrap {
+		if c.TLSCert != "" || c.TLSKey != "" {
+			return errors.New("enable-cert-bootstrap cannot be used with the tls-cert or tls-key")
+		}
+		if c.CertSecret == "" || c.ServiceName == "" || c.ServiceNamespace == "" {
+			return errors.New("certificate bootstrap requires the cert secret, service name and namespace")
+		}
+	}
+	if c.RegisterWebhook || c.DeregisterWebhook {
+		if c.WebhookAPI != WebhookAPIValidating && c.WebhookAPI != WebhookAPILegacy {
+			return fmt.Errorf("invalid webhook api: %s, expected %s or %s", c.WebhookAPI, WebhookAPIValidating, WebhookAPILegacy)
+		}
+		if c.WebhookFailurePolicy != "Ignore" && c.WebhookFailurePolicy != "Fail" {
+			return fmt.Errorf("invalid webhook failure policy: %s, expected Ignore or Fail", c.WebhookFailurePolicy)
+		}
+		if c.ServiceName == "" || c.ServiceNamespace == "" {
+			return errors.New("webhook registration requires the service name and namespace")
+		}
+		if c.RegisterWebhook && !c.EnableCertBootstrap && c.WebhookCABundle == "" {
+			return errors.New("webhook registration requires the webhook-ca-bundle, unless the certificates are bootstrapped")
+		}
+	}
+	if c.PolicyConfigMap != "" {
+		if namespace, name, err := cache.SplitMetaNamespaceKey(c.PolicyConfigMap); err != nil || namespace == "" || name == "" {
+			return errors.New("policy configmap must be in the format namespace/name")
+		}
+	}
+
+	return nil
+}
+
+// getConfig returns the current configuration
+func (c *controller) getConfig() *Config {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.config
+}
+
+// setConfig replaces the current configuration
+func (c *controller) setConfig(config *Config) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.config = config
+}
+
+// watchConfig watches the config file and reloads the configuration once the file has been left
+// unchanged for the reload delay; a configuration which fails to load is logged and the previous
+// one retained
+func (c *controller) watchConfig(path string, load func() (*Config, error)) error {
+	var timer *time.Timer
+
+	return watchFiles([]string{path}, c.stopCh, func() {
+		if timer != nil {
+			timer.Reset(configReloadDelay)
+			return
+		}
+		timer = time.AfterFunc(configReloadDelay, func() {
+			config, err := loadConfigFile(path, load)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err.Error(),
+					"path":  path,
+				}).Error("unable to reload the config file, retaining the current configuration")
+
+				return
+			}
+			c.reloadConfig(config)
+		})
+	})
+}
+
+// loadConfigFile reloads the configuration, rejecting a file which is empty or changes while being
+// loaded; a file written in place can be seen truncated or half written, which may well parse as
+// a valid configuration missing the options only set in the file
+func loadConfigFile(path string, load func() (*Config, error)) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, errors.New("config file is empty")
+	}
+
+	config, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	// @check the file was not changed underneath us, if so a further reload is pending
+	if current, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(content, current) {
+		return nil, errors.New("config file changed while being loaded")
+	}
+
+	return config, nil
+}
+
+// reloadConfig applies the new configuration, noting any options which require a restart
+func (c *controller) reloadConfig(config *Config) {
+	current := c.getConfig()
+	if reflect.DeepEqual(current, config) {
+		return
+	}
+	if requiresRestart(current, config) {
+		log.Warn("changes to listen, the tls options, enable-http-logging, enable-policy-crd, the audit options, the kubernetes client options, the webhook and service options and policy-configmap require a restart")
+	}
+	c.setConfig(config)
+
+	log.Info("reloaded the controller configuration")
+}
+
+// requiresRestart checks if the configurations differ in options only read on startup
+func requiresRestart(current, config *Config) bool {
+	return current.Listen != config.Listen ||
+		current.AuditInterval != config.AuditInterval ||
+		current.AuditLog != config.AuditLog ||
+		current.AuditLogMaxAge != config.AuditLogMaxAge ||
+		current.AuditLogMaxBackups != config.AuditLogMaxBackups ||
+		current.AuditLogMaxSize != config.AuditLogMaxSize ||
+		current.Kubeconfig != config.Kubeconfig ||
+		current.KubeContext != config.KubeContext ||
+		current.ClientQPS != config.ClientQPS ||
+		current.ClientBurst != config.ClientBurst ||
+		current.UserAgent != config.UserAgent ||
+		current.EnableLogging != config.EnableLogging ||
+		current.EnablePolicyCRD != config.EnablePolicyCRD ||
+		current.RegisterWebhook != config.RegisterWebhook ||
+		current.WebhookAPI != config.WebhookAPI ||
+		current.WebhookCABundle != config.WebhookCABundle ||
+		current.WebhookFailurePolicy != config.WebhookFailurePolicy ||
+		current.ServiceName != config.ServiceName ||
+		current.ServiceNamespace != config.ServiceNamespace ||
+		current.PolicyConfigMap != config.PolicyConfigMap ||
+		current.EnableCertBootstrap != config.EnableCertBootstrap ||
+		current.CertSecret != config.CertSecret ||
+		!reflect.DeepEqual(current.CertHosts, config.CertHosts) ||
+		current.TLSCert != config.TLSCert ||
+		current.TLSKey != config.TLSKey ||
+		current.TLSCA != config.TLSCA ||
+		current.EnableClientTLS != config.EnableClientTLS ||
+		!reflect.DeepEqual(current.ClientNames, config.ClientNames)
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+)
+
+const fakeConfig = `
+listen: 127.0.0.1:8443
+ignore-namespaces:
+- kube-system
+shared-hosts:
+- shared.example.com
+deny-domains:
+- domain: .example.com
+  namespaces:
+  - admin
+`
+
+func TestLoadConfig(t *testing.T) {
+	path := writeFakeConfig(t, fakeConfig)
+
+	config, err := loadConfig(path, newFakeCliContext(t))
+	require.NoError(t, err)
+	assert.Equal(t, &Config{
+		AuditLogMaxBackups:   5,
+		AuditLogMaxSize:      100,
+		CertSecret:           "ingress-admission-tls",
+		DenyDomains:          []DenyDomain{{Domain: ".example.com", Namespaces: []string{"admin"}}},
+		EnforcementMode:      EnforcementModeEnforce,
+		IgnoreNamespaces:     []string{"kube-system"},
+		Listen:               "127.0.0.1:8443",
+		ServiceName:          "ingress-admission",
+		ServiceNamespace:     "kube-admission",
+		SharedHosts:          []string{"shared.example.com"},
+		WebhookAPI:           WebhookAPIValidating,
+		WebhookFailurePolicy: "Fail",
+	}, config)
+}
+
+func TestLoadConfigFlagsOverride(t *testing.T) {
+	path := writeFakeConfig(t, fakeConfig)
+
+	config, err := loadConfig(path, newFakeCliContext(t,
+		"--listen=:9443",
+		"--ignore-namespace=default",
+		"--deny-domain=.bank.com",
+		"--enable-policy-crd",
+		"--audit-interval=5m",
+		"--kubeconfig=/kubeconfig",
+		"--context=dev",
+		"--client-qps=20.5",
+		"--client-burst=40",
+	))
+	require.NoError(t, err)
+	assert.Equal(t, ":9443", config.Listen)
+	assert.Equal(t, []string{"default"}, config.IgnoreNamespaces)
+	assert.Equal(t, []DenyDomain{{Domain: ".bank.com"}}, config.DenyDomains)
+	assert.Equal(t, []string{"shared.example.com"}, config.SharedHosts)
+	assert.True(t, config.EnablePolicyCRD)
+	assert.Equal(t, 5*time.Minute, config.AuditInterval)
+	assert.Equal(t, "/kubeconfig", config.Kubeconfig)
+	assert.Equal(t, "dev", config.KubeContext)
+	assert.Equal(t, 20.5, config.ClientQPS)
+	assert.Equal(t, 40, config.ClientBurst)
+}
+
+func TestLoadConfigZeroValues(t *testing.T) {
+	path := writeFakeConfig(t, "audit-log-max-backups: 0\naudit-log-max-size: 10\n")
+
+	config, err := loadConfig(path, newFakeCliContext(t))
+	require.NoError(t, err)
+	assert.Equal(t, 0, config.AuditLogMaxBackups)
+	assert.Equal(t, 10, config.AuditLogMaxSize)
+
+	config, err = loadConfig(path, newFakeCliContext(t, "--audit-log-max-backups=2"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, config.AuditLogMaxBackups)
+}
+
+func TestLoadConfigNoFile(t *testing.T) {
+	config, err := loadConfig("", newFakeCliContext(t))
+	require.NoError(t, err)
+	assert.Equal(t, ":8443", config.Listen)
+}
+
+func TestLoadConfigBad(t *testing.T) {
+	cs := []string{
+		"listen: [",
+		"unknown: true",
+		"deny-domains:\n- namespaces: [admin]",
+		"register-webhook: true\nwebhook-api: bad",
+		"register-webhook: true\nwebhook-failure-policy: bad",
+		"register-webhook: true\ntls-ca: /ca.pem",
+		"enable-client-tls: true",
+		"enforcement-mode: bad",
+		"policy-configmap: name",
+		"enable-cert-bootstrap: true\ntls-cert: /tls.pem",
+		"audit-interval: -1m",
+		"audit-log-max-size: -1",
+		"client-qps: -1",
+	}
+	for i, x := range cs {
+		_, err := loadConfig(writeFakeConfig(t, x), newFakeCliContext(t))
+		assert.Error(t, err, "case %d should have failed", i)
+	}
+
+	_, err := loadConfig(filepath.Join(t.TempDir(), "missing.yml"), newFakeCliContext(t))
+	assert.Error(t, err)
+}
+
+func TestReloadConfig(t *testing.T) {
+	c := newFakeController()
+	c.service.reloadConfig(&Config{Listen: ":8443", IgnoreNamespaces: []string{"test"}})
+	assert.Equal(t, []string{"test"}, c.service.getConfig().IgnoreNamespaces)
+}
+
+func TestRequiresRestart(t *testing.T) {
+	current := &Config{Listen: ":8443", ServiceName: "ingress-admission", ServiceNamespace: "kube-admission"}
+	assert.False(t, requiresRestart(current, &Config{Listen: ":8443", ServiceName: "ingress-admission", ServiceNamespace: "kube-admission", SharedHosts: []string{"a.example.com"}}))
+	assert.True(t, requiresRestart(current, &Config{Listen: ":8443", ServiceName: "other", ServiceNamespace: "kube-admission"}))
+	assert.True(t, requiresRestart(current, &Config{Listen: ":8443", ServiceName: "ingress-admission", ServiceNamespace: "other"}))
+	assert.True(t, requiresRestart(current, &Config{Listen: ":8443", ServiceName: "ingress-admission", ServiceNamespace: "kube-admission", WebhookCABundle: "ca.pem"}))
+	assert.True(t, requiresRestart(current, &Config{Listen: ":8443", ServiceName: "ingress-admission", ServiceNamespace: "kube-admission", WebhookFailurePolicy: "Fail"}))
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := writeFakeConfig(t, fakeConfig)
+	ctx := newFakeCliContext(t)
+	load := func() (*Config, error) { return loadConfig(path, ctx) }
+
+	config, err := load()
+	require.NoError(t, err)
+	c, _ := newController(*config)
+	defer c.stop()
+	require.NoError(t, c.watchConfig(path, load))
+
+	// @step: a bad configuration should be ignored
+	require.NoError(t, ioutil.WriteFile(path, []byte("listen: ["), 0644))
+	time.Sleep(2 * configReloadDelay)
+	assert.Equal(t, []string{"kube-system"}, c.getConfig().IgnoreNamespaces)
+
+	// @step: a truncated file should be ignored
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+	time.Sleep(2 * configReloadDelay)
+	assert.Equal(t, []string{"kube-system"}, c.getConfig().IgnoreNamespaces)
+
+	// @step: a valid configuration should be applied
+	require.NoError(t, ioutil.WriteFile(path, []byte("ignore-namespaces: [default]"), 0644))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"default"}, c.getConfig().IgnoreNamespaces)
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, ":8443", c.getConfig().Listen)
+}
+
+func writeFakeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestMergeKubeconfigFlags(t *testing.T) {
+	config, err := loadConfig("", newFakeCliContext(t, "--kubeconfig=/kubeconfig", "--context=dev"))
+	require.NoError(t, err)
+
+	// @check the global options are used unless given to the command
+	mergeKubeconfigFlags(config, newFakeCommandContext(t))
+	assert.Equal(t, "/kubeconfig", config.Kubeconfig)
+	assert.Equal(t, "dev", config.KubeContext)
+
+	mergeKubeconfigFlags(config, newFakeCommandContext(t, "--context=production"))
+	assert.Equal(t, "/kubeconfig", config.Kubeconfig)
+	assert.Equal(t, "production", config.KubeContext)
+}
+
+func newFakeCommandContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("command", flag.ContinueOnError)
+	for _, x := range kubeconfigFlags() {
+		x.Apply(set)
+	}
+	require.NoError(t, set.Parse(args))
+
+	return cli.NewContext(nil, set, newFakeCliContext(t))
+}
+
+func newFakeCliContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, x := range getCommandLineOptions() {
+		x.Apply(set)
+	}
+	require.NoError(t, set.Parse(args))
+
+	return cli.NewContext(nil, set, nil)
+}
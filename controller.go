@@ -17,144 +17,426 @@ limitations under the License.
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	log "github.com/sirupsen/logrus"
-	admission "k8s.io/api/admission/v1alpha1"
-	extensions "k8s.io/api/extensions/v1beta1"
+	admission "k8s.io/api/admission/v1"
+	api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 type controller struct {
+	sync.RWMutex
 	client kubernetes.Interface
 	engine *echo.Echo
 	config *Config
+	// metrics are the prometheus metrics for the controller
+	metrics *metrics
+	// namespaces is a lister for the namespace cache
+	namespaces corelisters.NamespaceLister
+	// ingresses is the ingress cache, indexed by hostname
+	ingresses cache.Indexer
+	// dynamic is a client used for the custom resources
+	dynamic dynamic.Interface
+	// policies is the cache of ingress domain policies
+	policies cache.Store
+	// certificates serves and reloads the tls certificate
+	certificates *certificateReloader
+	// caBundle is the bootstrapped ca and any previous cas yet to expire, if any
+	caBundle []byte
+	// violations is a count of the admitted policy violations by enforcement mode
+	violations map[string]int64
+	// auditLog records every admission decision, if enabled
+	auditLog *auditLogger
+	// recorder publishes the events for the violating ingresses
+	recorder record.EventRecorder
+	// report is the result of the last audit of the existing ingresses
+	report *auditReport
+	// clusterPolicy is the global policy from the policy configmap
+	clusterPolicy *ClusterPolicy
+	// whitelists is a cache of the compiled namespace whitelists
+	whitelists *whitelistCache
+	// synced is set once the informer caches have synced
+	synced int32
+	// stopCh is closed to stop the informers
+	stopCh chan struct{}
 }
 
 // newController creates, registers and starts the admission controller
 func newController(cfg Config) (*controller, error) {
 	log.Infof("starting the ingress admission controller, version: %s, listen: %s", Version, cfg.Listen)
-	c := &controller{config: &cfg}
+	c := &controller{
+		config:     &cfg,
+		stopCh:     make(chan struct{}),
+		violations: make(map[string]int64),
+		whitelists: newWhitelistCache(),
+	}
+
+	c.metrics = newMetrics(c)
 
 	c.engine = echo.New()
 	c.engine.HideBanner = true
 	c.engine.Use(middleware.Recover())
+	c.engine.Use(c.metrics.middleware())
 	if cfg.EnableLogging {
 		c.engine.Use(middleware.Logger())
 	}
 	c.engine.POST("/", c.reviewHandler)
+	c.engine.GET("/audit", c.auditHandler)
+	c.engine.GET("/explain", c.explainHandler)
 	c.engine.GET("/health", c.healthHandler)
+	c.engine.GET("/metrics", c.metrics.handler())
+	c.engine.GET("/ready", c.readyHandler)
+	c.engine.GET("/status", c.statusHandler)
 	c.engine.GET("/version", c.versionHandler)
 
 	return c, nil
 }
 
+// decision is the outcome of evaluating a request against the policy
+type decision struct {
+	// Allowed indicates the request is permitted by the policy
+	Allowed bool
+	// Reason is a short machine readable reason for the decision
+	Reason string
+	// Message is a human readable explanation of why the request was not permitted
+	Message string
+	// Hosts are the hostnames requested by the ingress
+	Hosts []string
+	// Matches is the whitelist entry which permitted each hostname
+	Matches map[string]string
+	// Sources is the policy source which permitted each hostname
+	Sources map[string]*policySource
+	// Namespace is the namespace the policy was resolved against, if it was retrieved
+	Namespace *api.Namespace
+}
+
+const (
+	// reasonPermitted is the request is covered by the policy
+	reasonPermitted = "permitted"
+	// reasonIgnored is the namespace is ignored by the policy
+	reasonIgnored = "ignored-namespace"
+	// reasonInvalidObject is the request is not for a valid ingress
+	reasonInvalidObject = "invalid-object"
+	// reasonDeniedDomain is a hostname is on the cluster deny list
+	reasonDeniedDomain = "denied-domain"
+	// reasonNamespaceLookup is the namespace could not be retrieved
+	reasonNamespaceLookup = "namespace-lookup"
+	// reasonNoPolicy is the namespace has no usable whitelist
+	reasonNoPolicy = "no-policy"
+	// reasonCatchAll is the ingress has a catch-all rule the namespace is not permitted
+	reasonCatchAll = "catch-all"
+	// reasonHostname is a rule hostname is not covered by the whitelist
+	reasonHostname = "hostname-not-permitted"
+	// reasonTLSHostname is a tls hostname is not covered by the whitelist
+	reasonTLSHostname = "tls-hostname-not-permitted"
+	// reasonHostClaimed is a hostname is already used by another namespace
+	reasonHostClaimed = "hostname-claimed"
+	// reasonHostLookup is the ingresses claiming a hostname could not be retrieved
+	reasonHostLookup = "hostname-lookup"
+)
+
+// permitted returns a decision allowing the request
+func permitted(reason string) *decision {
+	return &decision{Allowed: true, Reason: reason}
+}
+
+// denied returns a decision rejecting the request
+func denied(reason, message string, args ...interface{}) *decision {
+	return &decision{Reason: reason, Message: fmt.Sprintf(message, args...)}
+}
+
 // admit is responsible for applying the policy on the incoming request
-func (c *controller) admit(review *admission.AdmissionReview) error {
+func (c *controller) admit(request *admission.AdmissionRequest) (*admission.AdmissionResponse, error) {
+	start := time.Now()
+	config := c.getConfig()
+	cluster := c.getClusterPolicy()
 
-	ok, message := func() (bool, string) {
-		// @check if the object is a ingress
-		kind := review.Spec.Kind.Kind
-		if kind != "Ingress" {
-			return false, fmt.Sprintf("invalid object for review: %s, expected: ingress", kind)
-		}
+	result := c.evaluate(request, config, cluster)
+	if result.Reason == reasonIgnored {
+		log.WithFields(log.Fields{
+			"name":      request.Name,
+			"namespace": request.Namespace,
+		}).Info("ignoring the policy enforcement on this namespace")
+	}
 
-		ingress := &extensions.Ingress{}
-		if err := json.Unmarshal(review.Spec.Object.Raw, ingress); err != nil {
-			return false, fmt.Sprintf("unable to decode ingress spec: %s", err)
+	// @step: work out the outcome, a violation may be admitted under the enforcement mode
+	outcome := decisionAllowed
+	if !result.Allowed {
+		outcome = decisionDenied
+		if mode := c.enforcementMode(result, config, cluster); mode != EnforcementModeEnforce {
+			outcome = mode
 		}
+	}
+	c.metrics.recordDecision(outcome, request.Namespace, result.Reason)
+	c.writeAuditRecord(request, result, outcome, cluster.Revision, time.Since(start))
 
-		// @check if this namesapce is being ignored
-		for _, x := range c.config.IgnoreNamespaces {
-			if x == review.Spec.Namespace {
-				log.WithFields(log.Fields{
-					"name":      review.Spec.Name,
-					"namespace": review.Spec.Namespace,
-				}).Info("ignoring the policy enforcement on this namespace")
+	switch outcome {
+	case decisionAllowed:
+		return &admission.AdmissionResponse{Allowed: true}, nil
+	case decisionDenied:
+		c.recordDenial(request, result)
 
-				return true, ""
-			}
-		}
+		log.WithFields(log.Fields{
+			"namespace": request.Namespace,
+			"error":     result.Message,
+		}).Warn(result.Message)
 
-		// @check the domain being requested it whitelisted on the namespace
-		namespace, err := c.client.CoreV1().Namespaces().Get(review.Spec.Namespace, metav1.GetOptions{})
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error":     err.Error(),
-				"namespace": review.Spec.Namespace,
-			}).Error("unable to retrieve namespace")
+		return &admission.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Code:    http.StatusForbidden,
+				Message: result.Message,
+				Reason:  metav1.StatusReasonForbidden,
+				Status:  metav1.StatusFailure,
+			},
+		}, nil
+	}
 
-			return false, "unable to get namespace"
+	c.recordViolation(outcome)
+	log.WithFields(log.Fields{
+		"mode":      outcome,
+		"name":      request.Name,
+		"namespace": request.Namespace,
+		"error":     result.Message,
+	}).Warn("admitting request which violates the policy")
+
+	response := &admission.AdmissionResponse{Allowed: true}
+	if outcome == EnforcementModeWarn {
+		response.Warnings = []string{result.Message}
+	}
+
+	return response, nil
+}
+
+// evaluate applies the policy to the request, returning the decision and if denied why
+func (c *controller) evaluate(request *admission.AdmissionRequest, config *Config, cluster *ClusterPolicy) *decision {
+	// @check if the object is a ingress
+	kind := request.Kind.Kind
+	if kind != "Ingress" {
+		return denied(reasonInvalidObject, "invalid object for review: %s, expected: ingress", kind)
+	}
+
+	ingress, err := decodeIngress(request.Kind, request.Object.Raw)
+	if err != nil {
+		return denied(reasonInvalidObject, "unable to decode ingress spec: %s", err)
+	}
+	if ingress.Name == "" {
+		ingress.Name = request.Name
+	}
+
+	result := c.evaluateIngress(request.Namespace, ingress, config, cluster, nil)
+	result.Hosts = ingress.allHosts()
+
+	return result
+}
+
+// evaluateIngress applies the policy to the ingress within the namespace; the trace, if any, records
+// each check as it is performed. A traced evaluation only retrieves the namespace from the cache
+func (c *controller) evaluateIngress(ns string, ingress *ingressResource, config *Config, cluster *ClusterPolicy, trace *explanation) *decision {
+	// @check none of the hostnames are denied by the cluster policy
+	denyDomains := append(append([]DenyDomain{}, config.DenyDomains...), cluster.DenyDomains...)
+	for _, hostname := range ingress.allHosts() {
+		trace.denyDomains(hostname, ns, denyDomains)
+		if isDeniedDomain(hostname, ns, denyDomains) {
+			return denied(reasonDeniedDomain, "hostname: %s is denied by cluster policy", hostname)
 		}
+	}
 
-		// @check the annotation exists on the namespace
-		whitelist, found := namespace.GetAnnotations()[DomainWhitelistAnnotation]
-		if !found {
-			return false, fmt.Sprintf("namespace has no whitelist annotation: %s", DomainWhitelistAnnotation)
+	// @check if this namesapce is being ignored
+	if containedIn(ns, config.IgnoreNamespaces) || containedIn(ns, cluster.IgnoreNamespaces) {
+		trace.add("ignored-namespace", "", stepMatch, "the policy is not enforced on this namespace")
+		return permitted(reasonIgnored)
+	}
+	trace.add("ignored-namespace", "", stepPass, "the namespace is not ignored")
+
+	// @check the domain being requested it whitelisted on the namespace
+	getNamespace := c.getNamespace
+	if trace != nil {
+		getNamespace = c.getCachedNamespace
+	}
+	namespace, err := getNamespace(ns)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err.Error(),
+			"namespace": ns,
+		}).Error("unable to retrieve namespace")
+		trace.add("namespace", "", stepFail, fmt.Sprintf("unable to get namespace: %s", err))
+
+		return denied(reasonNamespaceLookup, "unable to get namespace")
+	}
+	result := c.evaluateNamespace(namespace, ingress, config, cluster, trace)
+	result.Namespace = namespace
+
+	return result
+}
+
+// evaluateNamespace applies the policy resolved for the namespace to the ingress
+func (c *controller) evaluateNamespace(namespace *api.Namespace, ingress *ingressResource, config *Config, cluster *ClusterPolicy, trace *explanation) *decision {
+	// @step: resolve the policy for the namespace from the domain policies and annotations
+	policy, err := c.resolvePolicy(namespace, config, cluster)
+	if err != nil {
+		trace.add("policy", "", stepFail, err.Error())
+		return denied(reasonNoPolicy, "%s", err)
+	}
+	whitelistedDomains, err := c.whitelists.compile(namespace.Name, policy.Domains)
+	if err != nil {
+		trace.add("policy", "", stepFail, fmt.Sprintf("namespace whitelist is invalid: %s", err))
+		return denied(reasonNoPolicy, "namespace whitelist is invalid: %s", err)
+	}
+	trace.add("policy", "", stepPass, fmt.Sprintf("whitelist: %s, catch-all: %t", strings.Join(policy.Domains, ","), policy.CatchAll))
+
+	// @check if the namespace is permitted to create catch-all ingresses
+	if !policy.CatchAll {
+		if len(ingress.Rules) == 0 && ingress.DefaultBackend != nil {
+			trace.add("catch-all", "", stepFail, "the ingress only has a default backend")
+			return denied(reasonCatchAll, "default backend only ingresses are not permitted by namespace policy")
 		}
+		for _, rule := range ingress.Rules {
+			if rule.Host == "" {
+				trace.add("catch-all", "", stepFail, "the ingress has a rule without a hostname")
+				return denied(reasonCatchAll, "rules without a hostname are not permitted by namespace policy")
+			}
+		}
+	}
 
-		// @check the whitelist is not empty
-		if whitelist == "" {
-			return false, "namespace whitelist is empty"
+	// @check if the hostname is covered by the whitelist
+	matches := make(map[string]string)
+	sources := make(map[string]*policySource)
+	for _, rule := range ingress.Rules {
+		if rule.Host == "" {
+			continue
 		}
-		whitelistedDomains := strings.Split(whitelist, ",")
+		trace.whitelist(rule.Host, whitelistedDomains)
+		entry, found := whitelistedDomains.match(rule.Host)
+		if !found {
+			return denied(reasonHostname, "hostname: %s is not permitted by namespace policy", rule.Host)
+		}
+		matches[rule.Host] = entry
+		sources[rule.Host] = policy.source(entry)
+	}
 
-		// @check if the hostname is covered by the whitelist
-		for _, rule := range ingress.Spec.Rules {
-			if found := hasDomain(rule.Host, whitelistedDomains); !found {
-				return false, fmt.Sprintf("hostname: %s is not permitted by namespace policy", rule.Host)
+	// @check if the tls hostnames are covered by the whitelist
+	for _, tls := range ingress.TLS {
+		for _, hostname := range tls.Hosts {
+			trace.whitelist(hostname, whitelistedDomains)
+			entry, found := whitelistedDomains.match(hostname)
+			if !found {
+				return denied(reasonTLSHostname, "tls hostname: %s is not permitted by namespace policy", hostname)
 			}
+			matches[hostname] = entry
+			sources[hostname] = policy.source(entry)
 		}
+	}
 
-		return true, ""
-	}()
-	if !ok {
-		log.WithFields(log.Fields{
-			"namespace": review.Spec.Namespace,
-			"error":     message,
-		}).Warn(message)
+	// @check the hostnames have not already been claimed by another namespace
+	for _, hostname := range ingress.hosts() {
+		owner, found, err := c.hostOwner(hostname, namespace.Name, config)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err.Error(),
+				"hostname": hostname,
+			}).Error("unable to retrieve ingresses by hostname")
 
-		review.Status.Allowed = false
-		review.Status.Result = &metav1.Status{
-			Code:    http.StatusForbidden,
-			Message: message,
-			Reason:  metav1.StatusReasonForbidden,
-			Status:  metav1.StatusFailure,
+			trace.add("hostname-claimed", "", stepFail, fmt.Sprintf("unable to check the hostname ownership: %s", err))
+			return denied(reasonHostLookup, "unable to check if hostname: %s is claimed by another namespace", hostname)
 		}
-
-		return nil
+		if found {
+			trace.add("hostname-claimed", "", stepFail, fmt.Sprintf("the hostname is already claimed by namespace: %s", owner))
+			return denied(reasonHostClaimed, "hostname: %s is already claimed by namespace: %s", hostname, owner)
+		}
+		trace.add("hostname-claimed", "", stepPass, "the hostname is not claimed by another namespace")
 	}
 
-	review.Status.Allowed = true
+	result := permitted(reasonPermitted)
+	result.Matches = matches
+	result.Sources = sources
+
+	return result
+}
+
+// connect creates the kubernetes clients, including the dynamic client when required
+func (c *controller) connect(config *rest.Config) error {
+	cfg := c.getConfig()
+
+	var err error
+	if c.client, err = kubernetes.NewForConfig(config); err != nil {
+		return err
+	}
+	if cfg.EnablePolicyCRD || cfg.WebhookAPI == WebhookAPILegacy {
+		if c.dynamic, err = dynamic.NewForConfig(config); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 // start is repsonsible for starting the service up
 func (c *controller) start() error {
+	cfg := c.getConfig()
+
 	// @step: attempt to create a kubernetes client
-	client, err := getKubernetesClient()
+	config, err := getKubernetesConfig(cfg)
 	if err != nil {
 		return err
 	}
-	c.client = client
+	if err := c.connect(config); err != nil {
+		return err
+	}
+	c.recorder = newEventRecorder(c.client, c.stopCh)
+
+	// @step: open the audit log if required
+	if c.auditLog, err = newAuditLogger(cfg); err != nil {
+		return err
+	}
+
+	// @step: start the informers
+	if err := c.startInformers(); err != nil {
+		return err
+	}
+
+	// @step: bootstrap the certificates or load them, reloading them on changes
+	switch {
+	case cfg.EnableCertBootstrap:
+		c.certificates = &certificateReloader{}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := c.bootstrapCertificates(ctx); err != nil {
+			return err
+		}
+		go c.renewCertificates()
+	case cfg.TLSCert != "" && cfg.TLSKey != "":
+		if c.certificates, err = newCertificateReloader(cfg.TLSCert, cfg.TLSKey); err != nil {
+			return err
+		}
+		if err := c.certificates.watch(c.stopCh); err != nil {
+			return err
+		}
+	}
 
 	// @step: configure the http server
-	tlsConfig, err := getTLSConfig(c.config)
+	tlsConfig, err := getTLSConfig(cfg, c.certificates)
 	if err != nil {
 		return err
 	}
 
 	// @step: create the http service
 	hs := &http.Server{
-		Addr:         c.config.Listen,
+		Addr:         cfg.Listen,
 		Handler:      c.engine,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
@@ -171,5 +453,41 @@ func (c *controller) start() error {
 		}
 	}()
 
+	// @step: start auditing the existing ingresses if required
+	if cfg.AuditInterval > 0 {
+		go c.runAudit(cfg.AuditInterval)
+	}
+
+	// @step: register the webhook with the apiserver if required
+	if cfg.RegisterWebhook {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := c.registerWebhook(ctx); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// stop is responsible for stopping the informers and deregistering the webhook if required
+func (c *controller) stop() {
+	if c.getConfig().DeregisterWebhook {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := c.deregisterWebhook(ctx); err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("unable to deregister the webhook")
+		}
+	}
+	close(c.stopCh)
+
+	if c.auditLog != nil {
+		if err := c.auditLog.close(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("unable to close the audit log")
+		}
+	}
+}
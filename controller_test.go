@@ -27,18 +27,19 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	admission "k8s.io/api/admission/v1alpha1"
+	admission "k8s.io/api/admission/v1"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 type request struct {
 	Method          string
 	URI             string
-	AdmissionReview *admission.AdmissionReview
+	AdmissionReview interface{}
 
-	ExpectedCode    int
-	ExpectedContent string
-	ExpectedStatus  *admission.AdmissionReviewStatus
+	ExpectedCode     int
+	ExpectedContent  string
+	ExpectedStatus   *legacyAdmissionReviewStatus
+	ExpectedResponse *admission.AdmissionResponse
 }
 
 type fakeController struct {
@@ -88,12 +89,21 @@ func (c *fakeController) runTests(t *testing.T, requests []request) {
 			assert.Equal(t, x.ExpectedContent, string(content), "case %d, expected: %s, got: %s", i, x.ExpectedContent, string(content))
 		}
 		if x.ExpectedStatus != nil {
-			status := &admission.AdmissionReview{}
+			status := &legacyAdmissionReview{}
 			if err := json.Unmarshal(content, status); err != nil {
 				t.Errorf("case %d, unable to decode responce, error: %s", i, err)
 				continue
 			}
 			assert.Equal(t, *x.ExpectedStatus, status.Status)
 		}
+		if x.ExpectedResponse != nil {
+			review := &admission.AdmissionReview{}
+			if err := json.Unmarshal(content, review); err != nil {
+				t.Errorf("case %d, unable to decode responce, error: %s", i, err)
+				continue
+			}
+			require.NotNil(t, review.Response, "case %d, response should not be nil", i)
+			assert.Equal(t, *x.ExpectedResponse, *review.Response, "case %d", i)
+		}
 	}
 }
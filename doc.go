@@ -16,11 +16,28 @@ limitations under the License.
 
 package main
 
+import "time"
+
 const (
 	// AdmissionControllerName is the name we register as
 	AdmissionControllerName = "ingress-admission.acp.homeoffice.gov.uk"
 	// DomainWhitelistAnnotation is the annotation which controls which domains you can use
 	DomainWhitelistAnnotation = "ingress-admission.acp.homeoffice.gov.uk/domains"
+	// CatchAllAnnotation is the annotation which permits a namespace to use rules without a hostname or default backends
+	CatchAllAnnotation = "ingress-admission.acp.homeoffice.gov.uk/allow-catch-all"
+	// EnforcementModeAnnotation is the annotation which overrides the enforcement mode for a namespace
+	EnforcementModeAnnotation = "ingress-admission.acp.homeoffice.gov.uk/enforcement-mode"
+	// PolicyConfigMapKey is the key in the policy configmap holding the cluster policy
+	PolicyConfigMapKey = "policy.yml"
+)
+
+const (
+	// EnforcementModeEnforce denies any requests which violate the policy
+	EnforcementModeEnforce = "enforce"
+	// EnforcementModeWarn admits requests which violate the policy, returning the violation as a warning
+	EnforcementModeWarn = "warn"
+	// EnforcementModeDryRun silently admits requests which violate the policy, logging the violation
+	EnforcementModeDryRun = "dryrun"
 )
 
 var (
@@ -30,22 +47,105 @@ var (
 	GitSHA = "unknown"
 )
 
+// DenyDomain is a domain which cannot be used outside of the permitted namespaces
+type DenyDomain struct {
+	// Domain is the domain being denied, using the same syntax as the whitelist
+	Domain string `yaml:"domain"`
+	// Namespaces is a collection of namespaces permitted to use the domain
+	Namespaces []string `yaml:"namespaces"`
+}
+
+const (
+	// WebhookAPIValidating registers the webhook as a ValidatingWebhookConfiguration
+	WebhookAPIValidating = "validating"
+	// WebhookAPILegacy registers the webhook as a legacy ExternalAdmissionHookConfiguration
+	WebhookAPILegacy = "legacy"
+)
+
+// ClusterPolicy is the global policy sourced from the policy configmap
+type ClusterPolicy struct {
+	// DefaultDomains is the whitelist for namespaces which have no policy of their own
+	DefaultDomains []string `yaml:"default-domains"`
+	// DenyDomains is a collection of domains denied regardless of the namespace whitelist
+	DenyDomains []DenyDomain `yaml:"deny-domains"`
+	// EnforcementMode overrides the enforcement mode from the command line
+	EnforcementMode string `yaml:"enforcement-mode"`
+	// IgnoreNamespaces is a collection of namespaces the policy is not enforced on
+	IgnoreNamespaces []string `yaml:"ignore-namespaces"`
+	// Revision is the resource version of the configmap the policy was read from
+	Revision string `yaml:"-"`
+}
+
 // Config is the configuration for the service
 type Config struct {
+	// AuditLog is the path of the audit log of the admission decisions, '-' for stdout
+	AuditLog string `yaml:"audit-log"`
+	// AuditLogMaxAge is the number of days to retain the rotated audit logs, zero retains them all
+	AuditLogMaxAge int `yaml:"audit-log-max-age"`
+	// AuditLogMaxBackups is the number of rotated audit logs to retain, zero retains them all
+	AuditLogMaxBackups int `yaml:"audit-log-max-backups"`
+	// AuditLogMaxSize is the size in megabytes at which the audit log is rotated
+	AuditLogMaxSize int `yaml:"audit-log-max-size"`
+	// AuditInterval is the interval at which the existing ingresses are audited, zero disables
+	AuditInterval time.Duration `yaml:"audit-interval"`
+	// CertHosts is a list of additional hostnames or ips for the bootstrapped certificate
+	CertHosts []string `yaml:"cert-hosts"`
+	// CertSecret is the name of the secret holding the bootstrapped certificates
+	CertSecret string `yaml:"cert-secret"`
+	// ClientBurst is the burst of requests permitted to the kubernetes api, zero uses the client default
+	ClientBurst int `yaml:"client-burst"`
+	// ClientQPS is the requests per second permitted to the kubernetes api, zero uses the client default
+	ClientQPS float64 `yaml:"client-qps"`
+	// ClientNames is a list of common or subject alternative names permitted to call us when using mutual tls
+	ClientNames []string `yaml:"client-names"`
+	// DenyDomains is a collection of domains denied regardless of the namespace whitelist
+	DenyDomains []DenyDomain `yaml:"deny-domains"`
+	// DeregisterWebhook indicates we should remove the webhook configuration on shutdown
+	DeregisterWebhook bool `yaml:"deregister-webhook"`
+	// DisableNamespaceAnnotations disables the legacy namespace annotations as a policy source
+	DisableNamespaceAnnotations bool `yaml:"disable-namespace-annotations"`
+	// EnableCertBootstrap indicates we should generate a ca and serving certificate
+	EnableCertBootstrap bool `yaml:"enable-cert-bootstrap"`
 	// EnableClientTLS indicates you want mutual tls
 	EnableClientTLS bool `yaml:"enable-client-tls"`
 	// EnableLogging indicates you want http logging
 	EnableLogging bool `yaml:"enable-logging"`
+	// EnforcementMode is the default enforcement mode, either enforce, warn or dryrun
+	EnforcementMode string `yaml:"enforcement-mode"`
+	// EnablePolicyCRD indicates we should watch the ingress domain policies
+	EnablePolicyCRD bool `yaml:"enable-policy-crd"`
 	// IgnoreNamespaces
 	IgnoreNamespaces []string `yaml:"ignore-namespaces"`
+	// KubeContext is the context within the kubeconfig to use
+	KubeContext string `yaml:"context"`
+	// Kubeconfig is the path to a kubeconfig, the in-cluster configuration is used when neither it or the context are set
+	Kubeconfig string `yaml:"kubeconfig"`
 	// Listen is the interface we are listening on
 	Listen string `yaml:"listen"`
+	// PolicyConfigMap is the namespace/name of a configmap holding the cluster policy
+	PolicyConfigMap string `yaml:"policy-configmap"`
+	// RegisterWebhook indicates we should register the webhook configuration on startup
+	RegisterWebhook bool `yaml:"register-webhook"`
+	// ServiceName is the name of the service fronting the controller
+	ServiceName string `yaml:"service-name"`
+	// ServiceNamespace is the namespace of the service fronting the controller
+	ServiceNamespace string `yaml:"service-namespace"`
+	// SharedHosts is a list of hostnames which ingresses in different namespaces may share
+	SharedHosts []string `yaml:"shared-hosts"`
 	// TLSCert is the path to a certificate
 	TLSCert string `yaml:"tls-cert"`
 	// TLSKey is the path to a private key
 	TLSKey string `yaml:"tls-key"`
 	// TLSCA is the path to a ca
 	TLSCA string `yaml:"tls-ca"`
+	// UserAgent is the user agent of the kubernetes api client
+	UserAgent string `yaml:"user-agent"`
 	// Verbose indicates verbose logging
 	Verbose bool `yaml:"verbose"`
+	// WebhookAPI is the api used to register the webhook, either validating or legacy
+	WebhookAPI string `yaml:"webhook-api"`
+	// WebhookCABundle is the path to the ca bundle which signed the serving certificate, injected into the webhook
+	WebhookCABundle string `yaml:"webhook-ca-bundle"`
+	// WebhookFailurePolicy is the failure policy of the webhook, either Ignore or Fail
+	WebhookFailurePolicy string `yaml:"webhook-failure-policy"`
 }
@@ -0,0 +1,114 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	api "k8s.io/api/core/v1"
+)
+
+// isEnforcementMode checks the mode is a known enforcement mode, empty implying the default
+func isEnforcementMode(mode string) bool {
+	switch mode {
+	case "", EnforcementModeEnforce, EnforcementModeWarn, EnforcementModeDryRun:
+		return true
+	}
+
+	return false
+}
+
+// enforcementModes are the enforcement modes, strictest first
+var enforcementModes = []string{EnforcementModeEnforce, EnforcementModeWarn, EnforcementModeDryRun}
+
+// enforcementMode returns the enforcement mode for the decision; the domain policies take precedence
+// over the namespace annotation, which takes precedence over the cluster policy and in turn the
+// command line. A namespace can never relax the denial of a denied or claimed hostname (or one whose
+// ownership could not be checked), as these protect the other tenants rather than the namespace itself
+func (c *controller) enforcementMode(result *decision, config *Config, cluster *ClusterPolicy) string {
+	relaxable := result.Reason != reasonDeniedDomain && result.Reason != reasonHostClaimed && result.Reason != reasonHostLookup
+	if namespace := result.Namespace; namespace != nil && relaxable {
+		if mode := c.policyEnforcementMode(namespace); mode != "" {
+			return mode
+		}
+		mode := namespace.GetAnnotations()[EnforcementModeAnnotation]
+		switch {
+		case config.DisableNamespaceAnnotations:
+		case mode != "" && isEnforcementMode(mode):
+			return mode
+		case mode != "":
+			log.WithFields(log.Fields{
+				"mode":      mode,
+				"namespace": namespace.Name,
+			}).Warn("namespace has an invalid enforcement mode, ignoring")
+		}
+	}
+	if cluster.EnforcementMode != "" {
+		return cluster.EnforcementMode
+	}
+	if config.EnforcementMode != "" {
+		return config.EnforcementMode
+	}
+
+	return EnforcementModeEnforce
+}
+
+// policyEnforcementMode returns the strictest enforcement mode of the domain policies selecting
+// the namespace, if any set one
+func (c *controller) policyEnforcementMode(namespace *api.Namespace) string {
+	policies, err := namespacePolicies(c.policies, namespace)
+	if err != nil {
+		return ""
+	}
+	for _, mode := range enforcementModes {
+		for _, x := range policies {
+			if x.Spec.EnforcementMode == mode {
+				return mode
+			}
+		}
+	}
+	for _, x := range policies {
+		if x.Spec.EnforcementMode != "" {
+			log.WithFields(log.Fields{
+				"mode":   x.Spec.EnforcementMode,
+				"policy": x.Name,
+			}).Warn("domain policy has an invalid enforcement mode, ignoring")
+		}
+	}
+
+	return ""
+}
+
+// recordViolation counts a policy violation admitted under the enforcement mode
+func (c *controller) recordViolation(mode string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.violations[mode]++
+}
+
+// getViolations returns a copy of the admitted violation counts
+func (c *controller) getViolations() map[string]int64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	violations := make(map[string]int64, len(c.violations))
+	for mode, count := range c.violations {
+		violations[mode] = count
+	}
+
+	return violations
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admission "k8s.io/api/admission/v1"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEnforcementMode(t *testing.T) {
+	c := newFakeController()
+	namespaces := make(map[string]*api.Namespace)
+	for name, mode := range map[string]string{"warn": EnforcementModeWarn, "bad": "bad"} {
+		namespaces[name] = &api.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{EnforcementModeAnnotation: mode},
+			},
+		}
+	}
+
+	cs := []struct {
+		Namespace string
+		Reason    string
+		Config    string
+		Cluster   string
+		Disabled  bool
+		Expected  string
+	}{
+		{Namespace: "missing", Expected: EnforcementModeEnforce},
+		{Namespace: "missing", Config: EnforcementModeDryRun, Expected: EnforcementModeDryRun},
+		{Namespace: "missing", Config: EnforcementModeDryRun, Cluster: EnforcementModeEnforce, Expected: EnforcementModeEnforce},
+		{Namespace: "warn", Config: EnforcementModeDryRun, Cluster: EnforcementModeEnforce, Expected: EnforcementModeWarn},
+		{Namespace: "warn", Config: EnforcementModeDryRun, Cluster: EnforcementModeEnforce, Disabled: true, Expected: EnforcementModeEnforce},
+		{Namespace: "warn", Reason: reasonDeniedDomain, Expected: EnforcementModeEnforce},
+		{Namespace: "warn", Reason: reasonHostClaimed, Expected: EnforcementModeEnforce},
+		{Namespace: "warn", Reason: reasonHostClaimed, Config: EnforcementModeDryRun, Expected: EnforcementModeDryRun},
+		{Namespace: "bad", Config: EnforcementModeDryRun, Expected: EnforcementModeDryRun},
+	}
+	for i, x := range cs {
+		result := &decision{Reason: x.Reason, Namespace: namespaces[x.Namespace]}
+		config := &Config{EnforcementMode: x.Config, DisableNamespaceAnnotations: x.Disabled}
+		mode := c.service.enforcementMode(result, config, &ClusterPolicy{EnforcementMode: x.Cluster})
+		assert.Equal(t, x.Expected, mode, "case %d", i)
+	}
+}
+
+func TestEnforcementModeDomainPolicy(t *testing.T) {
+	c := newFakeController()
+	c.service.policies = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for name, mode := range map[string]string{"a": EnforcementModeDryRun, "b": EnforcementModeWarn, "c": "bad"} {
+		c.service.policies.Add(&IngressDomainPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       IngressDomainPolicySpec{Namespaces: []string{"test"}, EnforcementMode: mode},
+		})
+	}
+	namespace := &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{EnforcementModeAnnotation: EnforcementModeDryRun},
+		},
+	}
+
+	// @check the strictest policy mode is taken over the annotation
+	mode := c.service.enforcementMode(&decision{Reason: reasonHostname, Namespace: namespace}, &Config{}, &ClusterPolicy{})
+	assert.Equal(t, EnforcementModeWarn, mode)
+	mode = c.service.enforcementMode(&decision{Reason: reasonHostClaimed, Namespace: namespace}, &Config{}, &ClusterPolicy{})
+	assert.Equal(t, EnforcementModeEnforce, mode)
+}
+
+func TestEnforcementModeReview(t *testing.T) {
+	c := newFakeController()
+	for name, mode := range map[string]string{"warn": EnforcementModeWarn, "dryrun": EnforcementModeDryRun} {
+		c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					DomainWhitelistAnnotation: "*.test.svc.cluster.local",
+					EnforcementModeAnnotation: mode,
+				},
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	warned := createFakeIngressReviewV1(admissionV1, "bad.example.com")
+	warned.Request.Namespace = "warn"
+	dryrun := createFakeIngressReviewV1(admissionV1, "bad.example.com")
+	dryrun.Request.Namespace = "dryrun"
+	legacy := createFakeIngressReview("bad.example.com")
+	legacy.Spec.Namespace = "warn"
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: warned,
+			ExpectedResponse: &admission.AdmissionResponse{
+				UID:      fakeUID,
+				Allowed:  true,
+				Warnings: []string{"hostname: bad.example.com is not permitted by namespace policy"},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:              "/",
+			Method:           http.MethodPost,
+			AdmissionReview:  dryrun,
+			ExpectedResponse: &admission.AdmissionResponse{UID: fakeUID, Allowed: true},
+			ExpectedCode:     http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: legacy,
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+
+	assert.Equal(t, map[string]int64{EnforcementModeWarn: 2, EnforcementModeDryRun: 1}, c.service.getViolations())
+}
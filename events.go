@@ -0,0 +1,123 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	admission "k8s.io/api/admission/v1"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// eventComponent is the component the events are recorded under
+	eventComponent = "ingress-admission"
+	// eventPolicyViolation is the reason for an existing ingress violating the policy
+	eventPolicyViolation = "PolicyViolation"
+	// eventIngressDenied is the reason for a denied admission request
+	eventIngressDenied = "IngressDenied"
+	// eventIngressAnnotation is the annotation on the namespace events naming the ingress
+	eventIngressAnnotation = "ingress-admission.acp.homeoffice.gov.uk/ingress"
+)
+
+// eventCorrelatorOptions limits the rate of events per object and aggregates similar events, so
+// a pipeline repeatedly applying a denied ingress does not flood the apiserver
+var eventCorrelatorOptions = record.CorrelatorOptions{
+	// @note: a burst of 25 events per object, refilled at one every five minutes
+	BurstSize: 25,
+	QPS:       1. / 300.,
+	// @note: similar events with differing messages are combined after 10 within 10 minutes
+	MaxEvents:            10,
+	MaxIntervalInSeconds: 600,
+	SpamKeyFunc:          eventSpamKey,
+}
+
+// eventSpamKey keys the rate limit on the involved object as the client-go default does, plus the
+// ingress named on a namespace event, so one ingress being repeatedly denied does not use up the
+// events for every other ingress in the namespace
+func eventSpamKey(event *api.Event) string {
+	return strings.Join([]string{
+		event.Source.Component,
+		event.Source.Host,
+		event.InvolvedObject.Kind,
+		event.InvolvedObject.Namespace,
+		event.InvolvedObject.Name,
+		string(event.InvolvedObject.UID),
+		event.InvolvedObject.APIVersion,
+		event.Type,
+		event.Annotations[eventIngressAnnotation],
+	}, "")
+}
+
+// newEventRecorder creates a recorder publishing the events to the api
+func newEventRecorder(client kubernetes.Interface, stopCh <-chan struct{}) record.EventRecorder {
+	broadcaster := record.NewBroadcaster(record.WithCorrelatorOptions(eventCorrelatorOptions))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+
+	return broadcaster.NewRecorder(scheme.Scheme, api.EventSource{Component: eventComponent})
+}
+
+// recordDenial records an event against the namespace, and the ingress when it is being updated,
+// describing why the request was denied
+func (c *controller) recordDenial(request *admission.AdmissionRequest, result *decision) {
+	if c.recorder == nil {
+		return
+	}
+	message := fmt.Sprintf("ingress: %s, hosts: %s, denied (%s): %s",
+		request.Name, strings.Join(result.Hosts, ","), result.Reason, result.Message)
+
+	// @note: the event is placed in the namespace itself so it is visible to the namespace owners
+	c.recorder.AnnotatedEventf(&api.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       request.Namespace,
+		Namespace:  request.Namespace,
+	}, map[string]string{eventIngressAnnotation: request.Name}, api.EventTypeWarning, eventIngressDenied, "%s", message)
+
+	if request.Operation == admission.Update {
+		c.recorder.Event(c.ingressReference(request.Namespace, request.Name), api.EventTypeWarning, eventIngressDenied, message)
+	}
+}
+
+// ingressReference returns the ingress from the cache, or a reference to it if not found
+func (c *controller) ingressReference(namespace, name string) runtime.Object {
+	if c.ingresses != nil {
+		if x, found, err := c.ingresses.GetByKey(namespace + "/" + name); err == nil && found {
+			if ingress, ok := x.(*networking.Ingress); ok {
+				return ingress
+			}
+		}
+	}
+
+	return &api.ObjectReference{
+		APIVersion: networkingV1.String(),
+		Kind:       "Ingress",
+		Name:       name,
+		Namespace:  namespace,
+	}
+}
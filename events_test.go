@@ -0,0 +1,121 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecordDenial(t *testing.T) {
+	c := newFakeController()
+	recorder := record.NewFakeRecorder(10)
+	c.service.recorder = recorder
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+		},
+	}, metav1.CreateOptions{})
+
+	updated := createFakeIngressReview("www.example.com")
+	updated.Spec.Operation = string(admission.Update)
+
+	c.runTests(t, []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("site.apps.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: updated,
+			ExpectedCode:    http.StatusOK,
+		},
+	})
+
+	// @step: a create records against the namespace, an update against the namespace and ingress
+	expected := "Warning IngressDenied ingress: test, hosts: www.example.com, denied (hostname-not-permitted): " +
+		"hostname: www.example.com is not permitted by namespace policy"
+	annotated := expected + " map[" + eventIngressAnnotation + ":test]"
+	require.Len(t, recorder.Events, 3)
+	assert.Equal(t, annotated, <-recorder.Events)
+	assert.Equal(t, annotated, <-recorder.Events)
+	assert.Equal(t, expected, <-recorder.Events)
+}
+
+func TestRecordDenialNotEnforced(t *testing.T) {
+	c := newFakeController()
+	recorder := record.NewFakeRecorder(10)
+	c.service.recorder = recorder
+	c.service.config.EnforcementMode = EnforcementModeWarn
+
+	c.runTests(t, []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+	})
+	assert.Empty(t, recorder.Events)
+}
+
+func TestIngressReference(t *testing.T) {
+	c := newFakeAuditController(t)
+
+	cached, ok := c.service.ingressReference("test", "bad").(*networking.Ingress)
+	require.True(t, ok)
+	assert.Equal(t, "bad", cached.Name)
+
+	assert.Equal(t, &api.ObjectReference{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "Ingress",
+		Name:       "missing",
+		Namespace:  "test",
+	}, c.service.ingressReference("test", "missing"))
+}
+
+func TestEventSpamKey(t *testing.T) {
+	event := func(ingress string) *api.Event {
+		return &api.Event{
+			ObjectMeta:     metav1.ObjectMeta{Annotations: map[string]string{eventIngressAnnotation: ingress}},
+			InvolvedObject: api.ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: "test", Namespace: "test"},
+			Source:         api.EventSource{Component: eventComponent},
+			Type:           api.EventTypeWarning,
+		}
+	}
+	assert.Equal(t, eventSpamKey(event("a")), eventSpamKey(event("a")))
+	assert.NotEqual(t, eventSpamKey(event("a")), eventSpamKey(event("b")))
+}
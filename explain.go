@@ -0,0 +1,273 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/labstack/echo"
+	"github.com/urfave/cli"
+)
+
+const (
+	// stepMatch is a entry which matched the hostname
+	stepMatch = "match"
+	// stepNoMatch is a entry which did not match the hostname
+	stepNoMatch = "no-match"
+	// stepExempt is a deny entry which matched but the namespace is exempt from
+	stepExempt = "exempt"
+	// stepPass is a check which passed
+	stepPass = "pass"
+	// stepFail is a check which failed, ending the evaluation
+	stepFail = "fail"
+	// stepSkipped is a check which was not applicable
+	stepSkipped = "skipped"
+)
+
+// explanation is a trace of the policy evaluated against a hostname in a namespace
+type explanation struct {
+	// Namespace is the namespace being explained
+	Namespace string `json:"namespace"`
+	// Host is the hostname being explained
+	Host string `json:"host"`
+	// Allowed indicates the hostname is permitted by the policy
+	Allowed bool `json:"allowed"`
+	// Reason is the machine readable reason for the decision
+	Reason string `json:"reason"`
+	// Message explains why the hostname is not permitted
+	Message string `json:"message,omitempty"`
+	// Mode is the enforcement mode for the namespace
+	Mode string `json:"mode"`
+	// Trace are the checks performed in the order they were evaluated
+	Trace []explainStep `json:"trace"`
+}
+
+// explainStep is a single check in the evaluation of the policy
+type explainStep struct {
+	// Check is the name of the check
+	Check string `json:"check"`
+	// Entry is the deny or whitelist entry being checked, if any
+	Entry string `json:"entry,omitempty"`
+	// Result is the outcome of the check
+	Result string `json:"result"`
+	// Detail describes why the check had the result
+	Detail string `json:"detail"`
+}
+
+// explainHandler explains the policy for a hostname within a namespace; as the explanation reveals
+// the policy of any namespace it is only served to the clients verified by mutual tls
+func (c *controller) explainHandler(ctx echo.Context) error {
+	if !c.getConfig().EnableClientTLS {
+		return ctx.String(http.StatusForbidden, "the explain endpoint requires client tls\n")
+	}
+	namespace, host := ctx.QueryParam("namespace"), ctx.QueryParam("host")
+	if namespace == "" || host == "" {
+		return ctx.String(http.StatusBadRequest, "namespace and host are required\n")
+	}
+
+	return ctx.JSON(http.StatusOK, c.explain(namespace, host))
+}
+
+// explainCommand returns the command line equivalent of the explain endpoint
+func explainCommand() cli.Command {
+	return cli.Command{
+		Name:  "explain",
+		Usage: "explains which policy entries permit or deny a hostname within a namespace",
+		Flags: append(kubeconfigFlags(),
+			cli.StringFlag{
+				Name:  "namespace",
+				Usage: "the namespace the ingress would be created in `NAMESPACE`",
+			},
+			cli.StringFlag{
+				Name:  "host",
+				Usage: "the hostname to explain `HOSTNAME`",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "the output format, text or json `FORMAT`",
+				Value: "text",
+			},
+		),
+		Action: func(ctx *cli.Context) error {
+			namespace, host := ctx.String("namespace"), ctx.String("host")
+			if namespace == "" || host == "" {
+				return cli.NewExitError("[error] --namespace and --host are required", 1)
+			}
+			ctl, err := newCommandController(ctx)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("[error] %s", err), 1)
+			}
+			defer close(ctl.stopCh)
+
+			e := ctl.explain(namespace, host)
+			switch ctx.String("output") {
+			case "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(e)
+			case "text":
+				err = e.write(os.Stdout)
+			default:
+				return cli.NewExitError(fmt.Sprintf("[error] unknown output format: %s", ctx.String("output")), 1)
+			}
+
+			return err
+		},
+	}
+}
+
+// explain evaluates the policy for the hostname in the namespace, tracing each check as it is
+// performed; the decision is that of a ingress with a single rule for the hostname
+func (c *controller) explain(namespace, host string) *explanation {
+	config := c.getConfig()
+	cluster := c.getClusterPolicy()
+
+	e := &explanation{Namespace: namespace, Host: host}
+	result := c.evaluateIngress(namespace, &ingressResource{Rules: []ingressRule{{Host: host}}}, config, cluster, e)
+	e.Allowed = result.Allowed
+	e.Reason = result.Reason
+	e.Message = result.Message
+	e.Mode = c.enforcementMode(result, config, cluster)
+
+	return e
+}
+
+// denyDomains records the deny list entries tried against the hostname, stopping at the entry
+// which denies it
+func (e *explanation) denyDomains(hostname, namespace string, denied []DenyDomain) {
+	if e == nil {
+		return
+	}
+	if len(denied) == 0 {
+		e.add("deny-domain", "", stepSkipped, "no deny domains are configured")
+	}
+	for _, x := range denied {
+		matched, detail := explainDomain(hostname, x.Domain)
+		switch {
+		case !matched:
+			e.add("deny-domain", x.Domain, stepNoMatch, detail)
+		case containedIn(namespace, x.Namespaces):
+			e.add("deny-domain", x.Domain, stepExempt, fmt.Sprintf("%s, but the namespace is exempt", detail))
+		default:
+			e.add("deny-domain", x.Domain, stepMatch, fmt.Sprintf("%s, the hostname is denied", detail))
+			return
+		}
+	}
+}
+
+// whitelist records the whitelist entries tried against the hostname in the order the whitelist
+// does, stopping at the entry which permits it
+func (e *explanation) whitelist(hostname string, whitelist *domainWhitelist) {
+	if e == nil {
+		return
+	}
+	for _, x := range whitelist.domains {
+		if strings.TrimSpace(x) == "" {
+			continue
+		}
+		matched, detail := explainDomain(hostname, x)
+		if !matched {
+			e.add("whitelist", x, stepNoMatch, detail)
+			continue
+		}
+		e.add("whitelist", x, stepMatch, detail)
+		return
+	}
+	for _, x := range whitelist.patterns {
+		if !x.re.MatchString(normalizeDomain(hostname)) {
+			e.add("whitelist", x.entry, stepNoMatch, fmt.Sprintf("hostname does not match the expression %s", x.re))
+			continue
+		}
+		e.add("whitelist", x.entry, stepMatch, fmt.Sprintf("hostname matches the expression %s", x.re))
+		return
+	}
+}
+
+// add appends a step to the trace, if tracing
+func (e *explanation) add(check, entry, result, detail string) {
+	if e == nil {
+		return
+	}
+	e.Trace = append(e.Trace, explainStep{Check: check, Entry: entry, Result: result, Detail: detail})
+}
+
+// write prints the explanation in a human readable form
+func (e *explanation) write(w io.Writer) error {
+	fmt.Fprintf(w, "namespace: %s, host: %s\n\n", e.Namespace, e.Host)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tENTRY\tRESULT\tDETAIL")
+	for _, x := range e.Trace {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", x.Check, x.Entry, x.Result, x.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if e.Allowed {
+		_, err := fmt.Fprintf(w, "\ndecision: allowed (%s)\n", e.Reason)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\ndecision: denied (%s): %s, enforcement mode: %s\n", e.Reason, e.Message, e.Mode)
+
+	return err
+}
+
+// explainDomain checks if the whitelist entry matches the hostname, describing why
+func explainDomain(hostname, entry string) (bool, string) {
+	hostname, entry = normalizeDomain(hostname), normalizeDomain(entry)
+	if hostname == "" {
+		return false, "hostname is empty"
+	}
+	matched := matchDomain(hostname, entry)
+
+	switch {
+	case strings.HasPrefix(entry, "**."):
+		domain := strings.TrimPrefix(entry, "**.")
+		if matched {
+			return true, fmt.Sprintf("hostname has one or more labels in front of %s", domain)
+		}
+		return false, fmt.Sprintf("hostname is not a subdomain of %s", domain)
+	case strings.HasPrefix(entry, "*."):
+		domain := strings.TrimPrefix(entry, "*.")
+		switch {
+		case matched:
+			return true, fmt.Sprintf("hostname has exactly one label in front of %s", domain)
+		case strings.HasSuffix(hostname, "."+domain):
+			return false, fmt.Sprintf("wildcard matches a single label, hostname has more than one in front of %s", domain)
+		}
+		return false, fmt.Sprintf("hostname is not a subdomain of %s", domain)
+	case strings.HasPrefix(entry, "."):
+		domain := strings.TrimPrefix(entry, ".")
+		if matched {
+			return true, fmt.Sprintf("hostname is %s or a subdomain of it", domain)
+		}
+		return false, fmt.Sprintf("hostname is neither %s nor a subdomain of it", domain)
+	}
+
+	if matched {
+		return true, "hostname matches exactly"
+	}
+
+	return false, "hostname does not match exactly"
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExplainDomain(t *testing.T) {
+	cs := []struct {
+		Hostname string
+		Entry    string
+		Expected bool
+		Detail   string
+	}{
+		{"site.example.com", "site.example.com", true, "hostname matches exactly"},
+		{"www.example.com", "site.example.com", false, "hostname does not match exactly"},
+		{"api.team.example.com", "*.example.com", false, "wildcard matches a single label, hostname has more than one in front of example.com"},
+		{"api.example.com", "*.example.com", true, "hostname has exactly one label in front of example.com"},
+		{"api.example.org", "*.example.com", false, "hostname is not a subdomain of example.com"},
+		{"api.team.example.com", "**.example.com", true, "hostname has one or more labels in front of example.com"},
+		{"example.com", "**.example.com", false, "hostname is not a subdomain of example.com"},
+		{"example.com", ".example.com", true, "hostname is example.com or a subdomain of it"},
+		{"example.org", ".example.com", false, "hostname is neither example.com nor a subdomain of it"},
+		{"", "site.example.com", false, "hostname is empty"},
+	}
+	for i, c := range cs {
+		matched, detail := explainDomain(c.Hostname, c.Entry)
+		assert.Equal(t, c.Expected, matched, "case %d, expected: %t, got: %t", i, c.Expected, matched)
+		assert.Equal(t, c.Detail, detail, "case %d", i)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	c := newFakeExplainController(t)
+
+	e := c.service.explain("test", "api.team.example.com")
+	assert.False(t, e.Allowed)
+	assert.Equal(t, reasonHostname, e.Reason)
+	assert.Equal(t, EnforcementModeEnforce, e.Mode)
+	assert.Equal(t, []explainStep{
+		{Check: "deny-domain", Entry: "*.internal.example.com", Result: stepNoMatch, Detail: "hostname is not a subdomain of internal.example.com"},
+		{Check: "ignored-namespace", Result: stepPass, Detail: "the namespace is not ignored"},
+		{Check: "policy", Result: stepPass, Detail: "whitelist: *.example.com,glob:pr-*.example.org, catch-all: false"},
+		{Check: "whitelist", Entry: "*.example.com", Result: stepNoMatch, Detail: "wildcard matches a single label, hostname has more than one in front of example.com"},
+		{Check: "whitelist", Entry: "glob:pr-*.example.org", Result: stepNoMatch, Detail: `hostname does not match the expression ^pr-[a-z0-9-]*\.example\.org$`},
+	}, e.Trace)
+
+	e = c.service.explain("test", "pr-10.example.org")
+	assert.True(t, e.Allowed)
+	require.Len(t, e.Trace, 6)
+	assert.Equal(t, explainStep{Check: "whitelist", Entry: "glob:pr-*.example.org", Result: stepMatch, Detail: `hostname matches the expression ^pr-[a-z0-9-]*\.example\.org$`}, e.Trace[4])
+	assert.Equal(t, explainStep{Check: "hostname-claimed", Result: stepPass, Detail: "the hostname is not claimed by another namespace"}, e.Trace[5])
+}
+
+func TestExplainStops(t *testing.T) {
+	c := newFakeExplainController(t)
+	c.service.config.IgnoreNamespaces = []string{"ignored"}
+
+	e := c.service.explain("test", "api.internal.example.com")
+	assert.False(t, e.Allowed)
+	assert.Equal(t, reasonDeniedDomain, e.Reason)
+	assert.Equal(t, []explainStep{
+		{Check: "deny-domain", Entry: "*.internal.example.com", Result: stepMatch, Detail: "hostname has exactly one label in front of internal.example.com, the hostname is denied"},
+	}, e.Trace)
+
+	e = c.service.explain("ignored", "www.example.org")
+	assert.True(t, e.Allowed)
+	assert.Equal(t, reasonIgnored, e.Reason)
+	assert.Equal(t, stepMatch, e.Trace[len(e.Trace)-1].Result)
+
+	// @check the namespace is never retrieved from the api
+	_, err := c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "missing"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	c.service.namespaces = nil
+	e = c.service.explain("missing", "www.example.com")
+	assert.False(t, e.Allowed)
+	assert.Equal(t, reasonNamespaceLookup, e.Reason)
+	assert.Equal(t, "namespace", e.Trace[len(e.Trace)-1].Check)
+	assert.Equal(t, stepFail, e.Trace[len(e.Trace)-1].Result)
+}
+
+func TestExplainClaimedHost(t *testing.T) {
+	c := newFakeExplainController(t)
+	other := createFakeNetworkingIngress("api.example.com")
+	other.Namespace = "ignored"
+	require.NoError(t, c.service.ingresses.Add(other))
+
+	e := c.service.explain("test", "api.example.com")
+	assert.False(t, e.Allowed)
+	assert.Equal(t, reasonHostClaimed, e.Reason)
+	assert.Equal(t, explainStep{
+		Check:  "hostname-claimed",
+		Result: stepFail,
+		Detail: "the hostname is already claimed by namespace: ignored",
+	}, e.Trace[len(e.Trace)-1])
+}
+
+func TestExplainHandler(t *testing.T) {
+	c := newFakeExplainController(t)
+	c.runTests(t, []request{
+		{
+			URI:             "/explain?namespace=test&host=www.example.com",
+			ExpectedCode:    http.StatusForbidden,
+			ExpectedContent: "the explain endpoint requires client tls\n",
+		},
+	})
+
+	c.service.config.EnableClientTLS = true
+	c.runTests(t, []request{
+		{
+			URI:             "/explain?namespace=test",
+			ExpectedCode:    http.StatusBadRequest,
+			ExpectedContent: "namespace and host are required\n",
+		},
+	})
+
+	resp, err := http.Get(c.server.URL + "/explain?namespace=test&host=www.example.com")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	e := &explanation{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(e))
+	assert.True(t, e.Allowed)
+	assert.Equal(t, "www.example.com", e.Host)
+	assert.NotEmpty(t, e.Trace)
+}
+
+func TestExplainWrite(t *testing.T) {
+	c := newFakeExplainController(t)
+	buffer := &bytes.Buffer{}
+	require.NoError(t, c.service.explain("test", "api.team.example.com").write(buffer))
+
+	content := buffer.String()
+	assert.Contains(t, content, "namespace: test, host: api.team.example.com")
+	assert.Contains(t, content, "whitelist          *.example.com")
+	assert.Contains(t, content, "decision: denied (hostname-not-permitted): hostname: api.team.example.com is not permitted by namespace policy, enforcement mode: enforce")
+}
+
+func newFakeExplainController(t *testing.T) *fakeController {
+	c := newFakeController()
+	c.service.config.DenyDomains = []DenyDomain{{Domain: "*.internal.example.com"}}
+	c.service.client = fake.NewSimpleClientset(
+		&api.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Annotations: map[string]string{DomainWhitelistAnnotation: "*.example.com,glob:pr-*.example.org"},
+			},
+		},
+		&api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ignored"}},
+	)
+	require.NoError(t, c.service.startInformers())
+	t.Cleanup(c.service.stop)
+	waitForSync(t, c.service)
+
+	return c
+}
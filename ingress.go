@@ -0,0 +1,242 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	extensions "k8s.io/api/extensions/v1beta1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ingressResource is a version agnostic representation of an ingress which the policy is applied to
+type ingressResource struct {
+	// Name is the name of the ingress
+	Name string
+	// Namespace is the namespace the ingress lives in
+	Namespace string
+	// Annotations are the annotations on the ingress
+	Annotations map[string]string
+	// DefaultBackend is the backend used when no rule matches
+	DefaultBackend *ingressBackend
+	// Rules are the host rules of the ingress
+	Rules []ingressRule
+	// TLS is the tls configuration of the ingress
+	TLS []ingressTLS
+}
+
+// ingressRule is a host rule on the ingress
+type ingressRule struct {
+	// Host is the hostname the rule applies to
+	Host string
+	// Paths are the http paths for the host
+	Paths []ingressPath
+}
+
+// ingressPath is a http path on a rule
+type ingressPath struct {
+	// Path is the path being matched
+	Path string
+	// PathType is the type of match, only set on the newer api versions
+	PathType string
+	// Backend is where the traffic is sent to
+	Backend ingressBackend
+}
+
+// ingressBackend is the destination of the traffic
+type ingressBackend struct {
+	// ServiceName is the name of the service
+	ServiceName string
+	// ServicePort is the name or number of the service port
+	ServicePort string
+	// Resource is a reference to a non service backend, i.e. kind/name
+	Resource string
+}
+
+// ingressTLS is a tls definition on the ingress
+type ingressTLS struct {
+	// Hosts are the hostnames included in the certificate
+	Hosts []string
+	// SecretName is the name of the secret holding the certificate
+	SecretName string
+}
+
+// ingressHostIndex is the name of the index of ingresses by hostname
+const ingressHostIndex = "host"
+
+var (
+	extensionsV1beta1 = schema.GroupVersion{Group: "extensions", Version: "v1beta1"}
+	networkingV1beta1 = schema.GroupVersion{Group: "networking.k8s.io", Version: "v1beta1"}
+	networkingV1      = schema.GroupVersion{Group: "networking.k8s.io", Version: "v1"}
+)
+
+// hosts returns the unique hostnames used by the rules of the ingress
+func (i *ingressResource) hosts() []string {
+	var list []string
+	seen := make(map[string]bool)
+	for _, x := range i.Rules {
+		host := strings.ToLower(x.Host)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		list = append(list, host)
+	}
+
+	return list
+}
+
+// allHosts returns the unique hostnames used by both the rules and tls of the ingress
+func (i *ingressResource) allHosts() []string {
+	list := i.hosts()
+	seen := make(map[string]bool)
+	for _, x := range list {
+		seen[x] = true
+	}
+	for _, tls := range i.TLS {
+		for _, x := range tls.Hosts {
+			host := strings.ToLower(x)
+			if host == "" || seen[host] {
+				continue
+			}
+			seen[host] = true
+			list = append(list, host)
+		}
+	}
+
+	return list
+}
+
+// decodeIngress decodes the raw object into an ingress resource based on its group and version
+func decodeIngress(kind metav1.GroupVersionKind, raw []byte) (*ingressResource, error) {
+	version := schema.GroupVersion{Group: kind.Group, Version: kind.Version}
+
+	switch version {
+	case extensionsV1beta1, networkingV1beta1:
+		// the networking.k8s.io/v1beta1 ingress shares the wire format of extensions/v1beta1
+		ingress := &extensions.Ingress{}
+		if err := json.Unmarshal(raw, ingress); err != nil {
+			return nil, err
+		}
+
+		return fromExtensionsIngress(ingress), nil
+	case networkingV1:
+		ingress := &networking.Ingress{}
+		if err := json.Unmarshal(raw, ingress); err != nil {
+			return nil, err
+		}
+
+		return fromNetworkingIngress(ingress), nil
+	}
+
+	return nil, fmt.Errorf("unsupported ingress version: %s", version)
+}
+
+// fromExtensionsIngress converts a extensions/v1beta1 ingress
+func fromExtensionsIngress(ingress *extensions.Ingress) *ingressResource {
+	backend := func(b extensions.IngressBackend) ingressBackend {
+		v := ingressBackend{ServiceName: b.ServiceName}
+		if b.ServiceName != "" {
+			v.ServicePort = b.ServicePort.String()
+		}
+		if b.Resource != nil {
+			v.Resource = b.Resource.Kind + "/" + b.Resource.Name
+		}
+
+		return v
+	}
+
+	resource := &ingressResource{
+		Name:        ingress.Name,
+		Namespace:   ingress.Namespace,
+		Annotations: ingress.Annotations,
+	}
+	if ingress.Spec.Backend != nil {
+		b := backend(*ingress.Spec.Backend)
+		resource.DefaultBackend = &b
+	}
+	for _, x := range ingress.Spec.Rules {
+		rule := ingressRule{Host: x.Host}
+		if x.HTTP != nil {
+			for _, p := range x.HTTP.Paths {
+				path := ingressPath{Path: p.Path, Backend: backend(p.Backend)}
+				if p.PathType != nil {
+					path.PathType = string(*p.PathType)
+				}
+				rule.Paths = append(rule.Paths, path)
+			}
+		}
+		resource.Rules = append(resource.Rules, rule)
+	}
+	for _, x := range ingress.Spec.TLS {
+		resource.TLS = append(resource.TLS, ingressTLS{Hosts: x.Hosts, SecretName: x.SecretName})
+	}
+
+	return resource
+}
+
+// fromNetworkingIngress converts a networking.k8s.io/v1 ingress
+func fromNetworkingIngress(ingress *networking.Ingress) *ingressResource {
+	backend := func(b networking.IngressBackend) ingressBackend {
+		v := ingressBackend{}
+		if b.Service != nil {
+			v.ServiceName = b.Service.Name
+			v.ServicePort = b.Service.Port.Name
+			if v.ServicePort == "" {
+				v.ServicePort = strconv.Itoa(int(b.Service.Port.Number))
+			}
+		}
+		if b.Resource != nil {
+			v.Resource = b.Resource.Kind + "/" + b.Resource.Name
+		}
+
+		return v
+	}
+
+	resource := &ingressResource{
+		Name:        ingress.Name,
+		Namespace:   ingress.Namespace,
+		Annotations: ingress.Annotations,
+	}
+	if ingress.Spec.DefaultBackend != nil {
+		b := backend(*ingress.Spec.DefaultBackend)
+		resource.DefaultBackend = &b
+	}
+	for _, x := range ingress.Spec.Rules {
+		rule := ingressRule{Host: x.Host}
+		if x.HTTP != nil {
+			for _, p := range x.HTTP.Paths {
+				path := ingressPath{Path: p.Path, Backend: backend(p.Backend)}
+				if p.PathType != nil {
+					path.PathType = string(*p.PathType)
+				}
+				rule.Paths = append(rule.Paths, path)
+			}
+		}
+		resource.Rules = append(resource.Rules, rule)
+	}
+	for _, x := range ingress.Spec.TLS {
+		resource.TLS = append(resource.TLS, ingressTLS{Hosts: x.Hosts, SecretName: x.SecretName})
+	}
+
+	return resource
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	networking "k8s.io/api/networking/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestDecodeIngressExtensionsV1beta1(t *testing.T) {
+	content, _ := json.Marshal(createFakeIngress(fakeHostname))
+
+	ingress, err := decodeIngress(metav1.GroupVersionKind{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}, content)
+	require.NoError(t, err)
+	require.NotNil(t, ingress)
+	assert.Equal(t, "test", ingress.Name)
+	assert.Equal(t, "test", ingress.Namespace)
+	assert.Equal(t, []ingressRule{{Host: fakeHostname}}, ingress.Rules)
+	assert.Equal(t, []ingressTLS{{Hosts: []string{fakeHostname}, SecretName: "tls"}}, ingress.TLS)
+	assert.Nil(t, ingress.DefaultBackend)
+}
+
+func TestDecodeIngressNetworkingV1beta1(t *testing.T) {
+	pathType := networkingv1beta1.PathTypePrefix
+	content, _ := json.Marshal(&networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test"},
+		Spec: networkingv1beta1.IngressSpec{
+			Backend: &networkingv1beta1.IngressBackend{ServiceName: "default", ServicePort: intstr.FromInt(80)},
+			Rules: []networkingv1beta1.IngressRule{
+				{
+					Host: fakeHostname,
+					IngressRuleValue: networkingv1beta1.IngressRuleValue{
+						HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+							Paths: []networkingv1beta1.HTTPIngressPath{
+								{
+									Path:     "/api",
+									PathType: &pathType,
+									Backend:  networkingv1beta1.IngressBackend{ServiceName: "api", ServicePort: intstr.FromString("http")},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	ingress, err := decodeIngress(metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v1beta1", Kind: "Ingress"}, content)
+	require.NoError(t, err)
+	require.NotNil(t, ingress)
+	assert.Equal(t, &ingressBackend{ServiceName: "default", ServicePort: "80"}, ingress.DefaultBackend)
+	assert.Equal(t, []ingressRule{
+		{
+			Host: fakeHostname,
+			Paths: []ingressPath{
+				{Path: "/api", PathType: "Prefix", Backend: ingressBackend{ServiceName: "api", ServicePort: "http"}},
+			},
+		},
+	}, ingress.Rules)
+}
+
+func TestDecodeIngressNetworkingV1(t *testing.T) {
+	content, _ := json.Marshal(createFakeNetworkingIngress(fakeHostname))
+
+	ingress, err := decodeIngress(metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}, content)
+	require.NoError(t, err)
+	require.NotNil(t, ingress)
+	assert.Equal(t, &ingressBackend{ServiceName: "default", ServicePort: "http"}, ingress.DefaultBackend)
+	assert.Equal(t, []ingressRule{
+		{
+			Host: fakeHostname,
+			Paths: []ingressPath{
+				{Path: "/", PathType: "Exact", Backend: ingressBackend{ServiceName: "web", ServicePort: "8080"}},
+			},
+		},
+	}, ingress.Rules)
+	assert.Equal(t, []ingressTLS{{Hosts: []string{fakeHostname}, SecretName: "tls"}}, ingress.TLS)
+}
+
+func TestDecodeIngressBadVersion(t *testing.T) {
+	ingress, err := decodeIngress(metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v2", Kind: "Ingress"}, []byte("{}"))
+	assert.Error(t, err)
+	assert.Nil(t, ingress)
+	assert.Equal(t, "unsupported ingress version: networking.k8s.io/v2", err.Error())
+}
+
+func TestDecodeIngressBadContent(t *testing.T) {
+	ingress, err := decodeIngress(metav1.GroupVersionKind{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}, []byte("{"))
+	assert.Error(t, err)
+	assert.Nil(t, ingress)
+}
+
+func TestFromExtensionsIngressResourceBackend(t *testing.T) {
+	group := "storage.k8s.io"
+	ingress := fromExtensionsIngress(&extensions.Ingress{
+		Spec: extensions.IngressSpec{
+			Rules: []extensions.IngressRule{
+				{
+					Host: fakeHostname,
+					IngressRuleValue: extensions.IngressRuleValue{
+						HTTP: &extensions.HTTPIngressRuleValue{
+							Paths: []extensions.HTTPIngressPath{
+								{Backend: extensions.IngressBackend{Resource: &api.TypedLocalObjectReference{APIGroup: &group, Kind: "Bucket", Name: "assets"}}},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	require.Len(t, ingress.Rules, 1)
+	assert.Equal(t, ingressBackend{Resource: "Bucket/assets"}, ingress.Rules[0].Paths[0].Backend)
+}
+
+func createFakeNetworkingIngress(hostname string) *networking.Ingress {
+	pathType := networking.PathTypeExact
+
+	return &networking.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+		},
+		Spec: networking.IngressSpec{
+			DefaultBackend: &networking.IngressBackend{
+				Service: &networking.IngressServiceBackend{Name: "default", Port: networking.ServiceBackendPort{Name: "http"}},
+			},
+			TLS: []networking.IngressTLS{
+				{
+					Hosts:      []string{hostname},
+					SecretName: "tls",
+				},
+			},
+			Rules: []networking.IngressRule{
+				{
+					Host: hostname,
+					IngressRuleValue: networking.IngressRuleValue{
+						HTTP: &networking.HTTPIngressRuleValue{
+							Paths: []networking.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: networking.IngressBackend{
+										Service: &networking.IngressServiceBackend{Name: "web", Port: networking.ServiceBackendPort{Number: 8080}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -34,46 +35,27 @@ func main() {
 		Usage:   "is a service used to control which domains a ingress resource is permitted to use",
 		Version: fmt.Sprintf("%s (git+sha: %s)", Version, GitSHA),
 
-		Flags: []cli.Flag{
-			cli.StringFlag{
-				Name:   "listen",
-				Usage:  "the network interace the service should listen on `INTERFACE`",
-				Value:  ":8443",
-				EnvVar: "LISTEN",
-			},
-			cli.StringFlag{
-				Name:   "tls-cert",
-				Usage:  "the path to a file containing the tls certificate `PATH`",
-				EnvVar: "TLS_CERT",
-			},
-			cli.StringFlag{
-				Name:   "tls-key",
-				Usage:  "the path to a file containing the tls key `PATH`",
-				EnvVar: "TLS_KEY",
-			},
-			cli.StringSliceFlag{
-				Name:   "ignore-namespace",
-				Usage:  "a collection of namespace you can ignore the policy enforcer",
-				EnvVar: "IGNORE_NAMESPACE",
-			},
-			cli.BoolFlag{
-				Name:   "enable-http-logging",
-				Usage:  "enable http logging on the service `BOOL`",
-				EnvVar: "ENABLE_HTTP_LOGGING",
-			},
+		Flags: getCommandLineOptions(),
+
+		Commands: []cli.Command{
+			auditCommand(),
+			checkCommand(),
+			explainCommand(),
 		},
 
 		Action: func(c *cli.Context) error {
 			log.SetFormatter(&log.JSONFormatter{})
 
+			// @step: load the configuration
+			path := c.String("config")
+			config, err := loadConfig(path, c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[error] invalid configuration, %s", err)
+				os.Exit(1)
+			}
+
 			// @step: create the controller
-			ctl, err := newController(Config{
-				EnableLogging:    c.Bool("enable-logging"),
-				IgnoreNamespaces: c.StringSlice("ignore-namespace"),
-				Listen:           c.String("listen"),
-				TLSCert:          c.String("tls-cert"),
-				TLSKey:           c.String("tls-key"),
-			})
+			ctl, err := newController(*config)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "[error] unable to initialize controller, %s", err)
 				os.Exit(1)
@@ -85,14 +67,282 @@ func main() {
 				os.Exit(1)
 			}
 
+			// @step: reload the configuration file on changes
+			if path != "" {
+				if err := ctl.watchConfig(path, func() (*Config, error) { return loadConfig(path, c) }); err != nil {
+					fmt.Fprintf(os.Stderr, "[error] unable to watch the config file, %s", err)
+					os.Exit(1)
+				}
+			}
+
 			// step: setup the termination signals
-			signalChannel := make(chan os.Signal)
+			signalChannel := make(chan os.Signal, 1)
 			signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 			<-signalChannel
 
+			ctl.stop()
+
 			return nil
 		},
 	}
 
 	app.Run(os.Args)
 }
+
+// newCommandController creates a controller for the command line tools from the global options,
+// connected to the cluster and with the caches synced; the caller should stop the informers by
+// closing the stop channel, which is closed here should the controller fail to start
+func newCommandController(ctx *cli.Context) (*controller, error) {
+	log.SetLevel(log.WarnLevel)
+
+	config, err := loadConfig(ctx.GlobalString("config"), ctx.Parent())
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration, %s", err)
+	}
+	mergeKubeconfigFlags(config, ctx)
+	ctl, err := newController(*config)
+	if err != nil {
+		return nil, err
+	}
+	// @note: unlike the controller the commands are usually run from a workstation, so the usual
+	// kubeconfig loading rules apply even when no kubeconfig is given
+	kubeconfig, err := loadKubeconfig(config.Kubeconfig, config.KubeContext)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the kubeconfig, %s", err)
+	}
+	setClientOptions(kubeconfig, config)
+	if err := ctl.connect(kubeconfig); err != nil {
+		close(ctl.stopCh)
+
+		return nil, fmt.Errorf("unable to connect to the cluster, %s", err)
+	}
+	// @note: the informers may have been started before any failure, so they are always stopped
+	if err := ctl.startInformers(); err != nil {
+		close(ctl.stopCh)
+
+		return nil, err
+	}
+	if err := ctl.waitForCaches(time.Minute); err != nil {
+		close(ctl.stopCh)
+
+		return nil, fmt.Errorf("unable to sync the caches, %s", err)
+	}
+
+	return ctl, nil
+}
+
+// kubeconfigFlags returns the kubeconfig options of the commands, which may be given after the
+// command as well as before it
+func kubeconfigFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "kubeconfig",
+			Usage: "the path to a kubeconfig, overriding the global option `PATH`",
+		},
+		cli.StringFlag{
+			Name:  "context",
+			Usage: "the context within the kubeconfig to use, overriding the global option `CONTEXT`",
+		},
+	}
+}
+
+// mergeKubeconfigFlags applies the kubeconfig options given to the command, falling back to the
+// global options already in the configuration
+func mergeKubeconfigFlags(config *Config, ctx *cli.Context) {
+	if ctx.IsSet("kubeconfig") {
+		config.Kubeconfig = ctx.String("kubeconfig")
+	}
+	if ctx.IsSet("context") {
+		config.KubeContext = ctx.String("context")
+	}
+}
+
+// getCommandLineOptions returns the command line options
+func getCommandLineOptions() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "the path to a yaml configuration file, reloaded on change `PATH`",
+			EnvVar: "CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "kubeconfig",
+			Usage:  "the path to a kubeconfig, the in-cluster configuration is used when neither it or the context are set `PATH`",
+			EnvVar: "KUBECONFIG",
+		},
+		cli.StringFlag{
+			Name:   "context",
+			Usage:  "the context within the kubeconfig to use `CONTEXT`",
+			EnvVar: "KUBE_CONTEXT",
+		},
+		cli.Float64Flag{
+			Name:   "client-qps",
+			Usage:  "the requests per second permitted to the kubernetes api, zero uses the client default `QPS`",
+			EnvVar: "CLIENT_QPS",
+		},
+		cli.IntFlag{
+			Name:   "client-burst",
+			Usage:  "the burst of requests permitted to the kubernetes api, zero uses the client default `BURST`",
+			EnvVar: "CLIENT_BURST",
+		},
+		cli.StringFlag{
+			Name:   "user-agent",
+			Usage:  "the user agent of the kubernetes api client, defaults to ingress-admission/VERSION `AGENT`",
+			EnvVar: "USER_AGENT",
+		},
+		cli.StringFlag{
+			Name:   "listen",
+			Usage:  "the network interace the service should listen on `INTERFACE`",
+			Value:  ":8443",
+			EnvVar: "LISTEN",
+		},
+		cli.StringFlag{
+			Name:   "tls-cert",
+			Usage:  "the path to a file containing the tls certificate `PATH`",
+			EnvVar: "TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "tls-key",
+			Usage:  "the path to a file containing the tls key `PATH`",
+			EnvVar: "TLS_KEY",
+		},
+		cli.BoolFlag{
+			Name:   "enable-cert-bootstrap",
+			Usage:  "generate a ca and serving certificate for the service, stored in the cert-secret `BOOL`",
+			EnvVar: "ENABLE_CERT_BOOTSTRAP",
+		},
+		cli.StringFlag{
+			Name:   "cert-secret",
+			Usage:  "the name of the secret in the service namespace holding the bootstrapped certificates `NAME`",
+			Value:  "ingress-admission-tls",
+			EnvVar: "CERT_SECRET",
+		},
+		cli.StringSliceFlag{
+			Name:   "cert-host",
+			Usage:  "an additional hostname or ip for the bootstrapped certificate `HOST`",
+			EnvVar: "CERT_HOST",
+		},
+		cli.StringFlag{
+			Name:   "tls-ca",
+			Usage:  "the path to a file containing the ca used to verify client certificates `PATH`",
+			EnvVar: "TLS_CA",
+		},
+		cli.BoolFlag{
+			Name:   "enable-client-tls",
+			Usage:  "require and verify client certificates signed by the tls-ca `BOOL`",
+			EnvVar: "ENABLE_CLIENT_TLS",
+		},
+		cli.StringSliceFlag{
+			Name:   "client-name",
+			Usage:  "a common or subject alternative name permitted on the client certificate `NAME`",
+			EnvVar: "CLIENT_NAME",
+		},
+		cli.StringSliceFlag{
+			Name:   "ignore-namespace",
+			Usage:  "a collection of namespace you can ignore the policy enforcer",
+			EnvVar: "IGNORE_NAMESPACE",
+		},
+		cli.StringSliceFlag{
+			Name:   "deny-domain",
+			Usage:  "a domain which is denied outside the listed namespaces `DOMAIN[:NAMESPACE:...]`",
+			EnvVar: "DENY_DOMAIN",
+		},
+		cli.StringFlag{
+			Name:   "policy-configmap",
+			Usage:  "the configmap holding the cluster policy, watched for changes `NAMESPACE/NAME`",
+			EnvVar: "POLICY_CONFIGMAP",
+		},
+		cli.StringFlag{
+			Name:   "enforcement-mode",
+			Usage:  "the default enforcement mode, enforce, warn or dryrun `MODE`",
+			Value:  EnforcementModeEnforce,
+			EnvVar: "ENFORCEMENT_MODE",
+		},
+		cli.DurationFlag{
+			Name:   "audit-interval",
+			Usage:  "the interval to audit the existing ingresses against the policy, zero disables `DURATION`",
+			EnvVar: "AUDIT_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "audit-log",
+			Usage:  "the path to write a json audit log of every admission decision, '-' for stdout `PATH`",
+			EnvVar: "AUDIT_LOG",
+		},
+		cli.IntFlag{
+			Name:   "audit-log-max-size",
+			Usage:  "the size in megabytes at which the audit log is rotated `SIZE`",
+			Value:  100,
+			EnvVar: "AUDIT_LOG_MAX_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "audit-log-max-backups",
+			Usage:  "the number of rotated audit logs to retain, zero retains all `COUNT`",
+			Value:  5,
+			EnvVar: "AUDIT_LOG_MAX_BACKUPS",
+		},
+		cli.IntFlag{
+			Name:   "audit-log-max-age",
+			Usage:  "the number of days to retain the rotated audit logs, zero retains all `DAYS`",
+			EnvVar: "AUDIT_LOG_MAX_AGE",
+		},
+		cli.StringSliceFlag{
+			Name:   "shared-host",
+			Usage:  "a hostname (or wildcard) which ingresses in different namespaces are permitted to share",
+			EnvVar: "SHARED_HOST",
+		},
+		cli.BoolFlag{
+			Name:   "enable-policy-crd",
+			Usage:  "use the IngressDomainPolicy custom resources as a source of policy `BOOL`",
+			EnvVar: "ENABLE_POLICY_CRD",
+		},
+		cli.BoolFlag{
+			Name:   "disable-namespace-annotations",
+			Usage:  "ignore the legacy namespace whitelist annotations `BOOL`",
+			EnvVar: "DISABLE_NAMESPACE_ANNOTATIONS",
+		},
+		cli.BoolFlag{
+			Name:   "register-webhook",
+			Usage:  "register the webhook configuration with the apiserver on startup `BOOL`",
+			EnvVar: "REGISTER_WEBHOOK",
+		},
+		cli.BoolFlag{
+			Name:   "deregister-webhook",
+			Usage:  "remove the webhook configuration from the apiserver on shutdown `BOOL`",
+			EnvVar: "DEREGISTER_WEBHOOK",
+		},
+		cli.StringFlag{
+			Name:   "webhook-api",
+			Usage:  "the api used to register the webhook, validating or legacy `API`",
+			Value:  WebhookAPIValidating,
+			EnvVar: "WEBHOOK_API",
+		},
+		cli.StringFlag{
+			Name:   "webhook-ca-bundle",
+			Usage:  "the path to the ca bundle which signed the serving certificate, injected into the webhook `PATH`",
+			EnvVar: "WEBHOOK_CA_BUNDLE",
+		},
+		cli.StringFlag{
+			Name:   "webhook-failure-policy",
+			Usage:  "the failure policy of the registered webhook, Fail or Ignore (which admits any ingress while the controller is unavailable) `POLICY`",
+			Value:  "Fail",
+			EnvVar: "WEBHOOK_FAILURE_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "service-name",
+			Usage:  "the name of the service fronting the controller `NAME`",
+			Value:  "ingress-admission",
+			EnvVar: "SERVICE_NAME",
+		},
+		cli.StringFlag{
+			Name:   "service-namespace",
+			Usage:  "the namespace of the service fronting the controller `NAMESPACE`",
+			Value:  "kube-admission",
+			EnvVar: "KUBE_NAMESPACE",
+		},
+		cli.BoolFlag{
+			Name:   "enable-http-logging",
+			Usage:  "enable http logging on the service `BOOL`",
+			EnvVar: "ENABLE_HTTP_LOGGING",
+		},
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// metricsNamespace is the prefix for all the metrics
+	metricsNamespace = "ingress_admission"
+	// decisionAllowed is a request permitted by the policy
+	decisionAllowed = "allowed"
+	// decisionDenied is a request denied by the policy
+	decisionDenied = "denied"
+)
+
+// metrics are the prometheus metrics exported by the controller
+type metrics struct {
+	// registry is the registry the metrics are exported from
+	registry *prometheus.Registry
+	// decisions is a count of the admission decisions by namespace and reason
+	decisions *prometheus.CounterVec
+	// latency is the time taken to handle the http requests
+	latency *prometheus.HistogramVec
+	// lookupFailures is a count of the failed lookups against the apiserver
+	lookupFailures *prometheus.CounterVec
+}
+
+// newMetrics creates and registers the metrics for the controller
+func newMetrics(c *controller) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "decisions_total",
+			Help:      "The number of admission decisions by decision, namespace and reason",
+		}, []string{"decision", "namespace", "reason"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "The latency of the http handlers",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "path", "code"}),
+		lookupFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lookup_failures_total",
+			Help:      "The number of failed lookups against the apiserver by resource",
+		}, []string{"resource"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.decisions,
+		m.latency,
+		m.lookupFailures,
+		&stateCollector{controller: c},
+	)
+
+	return m
+}
+
+// recordDecision increments the decision counter; the decision is either allowed, denied or the
+// enforcement mode the violation was admitted under
+func (m *metrics) recordDecision(decision, namespace, reason string) {
+	m.decisions.WithLabelValues(decision, namespace, reason).Inc()
+}
+
+// recordLookupFailure increments the failed lookups for the resource
+func (m *metrics) recordLookupFailure(resource string) {
+	m.lookupFailures.WithLabelValues(resource).Inc()
+}
+
+// middleware records the latency of the http handlers
+func (m *metrics) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			start := time.Now()
+			err := next(ctx)
+			if err != nil {
+				ctx.Error(err)
+			}
+			m.latency.WithLabelValues(
+				ctx.Request().Method,
+				ctx.Path(),
+				strconv.Itoa(ctx.Response().Status),
+			).Observe(time.Since(start).Seconds())
+
+			return nil
+		}
+	}
+}
+
+// handler returns the http handler exporting the metrics
+func (m *metrics) handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+var (
+	cacheSyncedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "cache_synced"),
+		"Indicates if the informer caches have synced",
+		nil, nil,
+	)
+	certificateExpiryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "certificate_expiry_timestamp_seconds"),
+		"The time the serving certificate expires",
+		nil, nil,
+	)
+	auditViolationsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "audit_violations"),
+		"The number of existing ingresses violating the policy at the last audit by namespace",
+		[]string{"namespace"}, nil,
+	)
+	auditTimestampDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "audit_last_run_timestamp_seconds"),
+		"The time the last audit was completed",
+		nil, nil,
+	)
+	auditIngressesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "audit_ingresses"),
+		"The number of existing ingresses evaluated at the last audit",
+		nil, nil,
+	)
+)
+
+// stateCollector exports the current state of the controller at the time of the scrape
+type stateCollector struct {
+	controller *controller
+}
+
+// Describe implements the prometheus collector
+func (s *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheSyncedDesc
+	ch <- certificateExpiryDesc
+	ch <- auditViolationsDesc
+	ch <- auditTimestampDesc
+	ch <- auditIngressesDesc
+}
+
+// Collect implements the prometheus collector
+func (s *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	synced := 0.0
+	if s.controller.isReady() {
+		synced = 1
+	}
+	ch <- prometheus.MustNewConstMetric(cacheSyncedDesc, prometheus.GaugeValue, synced)
+
+	if certificates := s.controller.certificates; certificates != nil {
+		if status := certificates.status(); status != nil {
+			ch <- prometheus.MustNewConstMetric(certificateExpiryDesc, prometheus.GaugeValue, float64(status.NotAfter.Unix()))
+		}
+	}
+
+	if report := s.controller.getAuditReport(); report != nil {
+		ch <- prometheus.MustNewConstMetric(auditTimestampDesc, prometheus.GaugeValue, float64(report.Time.Unix()))
+		ch <- prometheus.MustNewConstMetric(auditIngressesDesc, prometheus.GaugeValue, float64(report.Ingresses))
+
+		counts := make(map[string]int)
+		for _, x := range report.Violations {
+			counts[x.Namespace]++
+		}
+		for namespace, count := range counts {
+			ch <- prometheus.MustNewConstMetric(auditViolationsDesc, prometheus.GaugeValue, float64(count), namespace)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	c := newFakeController()
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+		},
+	}, metav1.CreateOptions{})
+
+	c.runTests(t, []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("site.apps.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+	})
+	_, err := c.service.getNamespace("missing")
+	require.Error(t, err)
+
+	content := getFakeMetrics(t, c)
+	assert.Contains(t, content, `ingress_admission_decisions_total{decision="allowed",namespace="test",reason="permitted"} 1`)
+	assert.Contains(t, content, `ingress_admission_decisions_total{decision="denied",namespace="test",reason="hostname-not-permitted"} 1`)
+	assert.Contains(t, content, `ingress_admission_lookup_failures_total{resource="namespaces"} 1`)
+	assert.Contains(t, content, `ingress_admission_http_request_duration_seconds_count{code="200",method="POST",path="/"} 2`)
+	assert.Contains(t, content, "ingress_admission_cache_synced 0")
+	assert.NotContains(t, content, "ingress_admission_certificate_expiry_timestamp_seconds ")
+}
+
+func TestMetricsEnforcementMode(t *testing.T) {
+	c := newFakeController()
+	c.service.config.EnforcementMode = EnforcementModeDryRun
+
+	c.runTests(t, []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.example.com"),
+			ExpectedCode:    http.StatusOK,
+		},
+	})
+	assert.Contains(t, getFakeMetrics(t, c), `ingress_admission_decisions_total{decision="dryrun",namespace="test",reason="namespace-lookup"} 1`)
+}
+
+func TestMetricsState(t *testing.T) {
+	c := newFakeAuditController(t)
+	c.service.certificates = &certificateReloader{}
+	cert := createFakeCertificate(t, "ingress-admission", nil)
+	require.NoError(t, c.service.certificates.update(cert.certPEM, cert.keyPEM))
+	c.service.audit()
+
+	content := getFakeMetrics(t, c)
+	assert.Contains(t, content, "ingress_admission_cache_synced 1")
+	assert.Contains(t, content, "ingress_admission_certificate_expiry_timestamp_seconds ")
+	assert.Contains(t, content, `ingress_admission_audit_violations{namespace="test"} 1`)
+	assert.Contains(t, content, "ingress_admission_audit_last_run_timestamp_seconds ")
+	assert.Contains(t, content, "ingress_admission_audit_ingresses 2")
+}
+
+func getFakeMetrics(t *testing.T, c *fakeController) string {
+	resp, err := http.Get(c.server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	content, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(content)
+}
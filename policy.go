@@ -0,0 +1,230 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// domainPolicyResource is the resource for the ingress domain policies
+var domainPolicyResource = schema.GroupVersionResource{
+	Group:    "ingress-admission.acp.homeoffice.gov.uk",
+	Version:  "v1alpha1",
+	Resource: "ingressdomainpolicies",
+}
+
+// IngressDomainPolicy is a cluster scoped policy controlling the domains the selected namespaces can use
+type IngressDomainPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	// Spec is the specification of the policy
+	Spec IngressDomainPolicySpec `json:"spec"`
+}
+
+// IngressDomainPolicySpec is the specification for a domain policy
+type IngressDomainPolicySpec struct {
+	// Namespaces is a list of namespace names the policy applies to
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces the policy applies to by label
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Domains is the whitelist of domains, using the same syntax as the namespace annotation
+	Domains []string `json:"domains,omitempty"`
+	// AllowCatchAll permits rules without a hostname and default backend only ingresses
+	AllowCatchAll bool `json:"allowCatchAll,omitempty"`
+	// EnforcementMode overrides the enforcement mode for the selected namespaces
+	EnforcementMode string `json:"enforcementMode,omitempty"`
+}
+
+// namespacePolicy is the effective policy for a namespace
+type namespacePolicy struct {
+	// Domains is the combined whitelist from all the sources
+	Domains []string
+	// CatchAll indicates the namespace is permitted catch-all ingresses
+	CatchAll bool
+	// Sources are where the whitelist was gathered from
+	Sources []*policySource
+}
+
+// policySource is a resource the whitelist of a namespace was gathered from
+type policySource struct {
+	// Kind is the kind of resource, IngressDomainPolicy, Namespace or ConfigMap
+	Kind string `json:"kind"`
+	// Name is the name of the resource
+	Name string `json:"name"`
+	// Revision is the resource version of the resource
+	Revision string `json:"revision,omitempty"`
+	// Domains are the whitelist entries the resource provided
+	Domains []string `json:"-"`
+}
+
+// source returns the source which provided the whitelist entry, if any
+func (p *namespacePolicy) source(entry string) *policySource {
+	for _, x := range p.Sources {
+		for _, domain := range x.Domains {
+			if strings.TrimSpace(domain) == entry {
+				return x
+			}
+		}
+	}
+
+	return nil
+}
+
+// selects checks if the policy applies to the namespace; unlike elsewhere in kubernetes an empty
+// namespace selector selects nothing, the cluster default domains being the way to whitelist
+// domains across every namespace
+func (p *IngressDomainPolicy) selects(namespace *api.Namespace) (bool, error) {
+	if containedIn(namespace.Name, p.Spec.Namespaces) {
+		return true, nil
+	}
+	if p.Spec.NamespaceSelector == nil {
+		return false, nil
+	}
+	if len(p.Spec.NamespaceSelector.MatchLabels) == 0 && len(p.Spec.NamespaceSelector.MatchExpressions) == 0 {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(namespace.GetLabels())), nil
+}
+
+// toDomainPolicy is a informer transform converting the unstructured resource into a domain policy
+func toDomainPolicy(obj interface{}) (interface{}, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return obj, nil
+	}
+	policy := &IngressDomainPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// namespacePolicies returns the domain policies which apply to the namespace, sorted by name
+func namespacePolicies(store cache.Store, namespace *api.Namespace) ([]*IngressDomainPolicy, error) {
+	if store == nil {
+		return nil, nil
+	}
+
+	var list []*IngressDomainPolicy
+	for _, x := range store.List() {
+		policy, ok := x.(*IngressDomainPolicy)
+		if !ok {
+			continue
+		}
+		selected, err := policy.selects(namespace)
+		if err != nil {
+			return nil, fmt.Errorf("policy: %s has an invalid namespace selector, %s", policy.Name, err)
+		}
+		if selected {
+			list = append(list, policy)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+// resolvePolicy gathers the effective policy for the namespace from the domain policies and, unless
+// disabled, the legacy namespace annotations; the configuration and cluster policy are those the
+// request is being evaluated against. The error is the reason the request should be denied
+func (c *controller) resolvePolicy(namespace *api.Namespace, config *Config, cluster *ClusterPolicy) (*namespacePolicy, error) {
+	policies, err := namespacePolicies(c.policies, namespace)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err.Error(),
+			"namespace": namespace.Name,
+		}).Error("unable to evaluate the domain policies")
+
+		return nil, errors.New("unable to evaluate domain policies")
+	}
+
+	resolved := &namespacePolicy{}
+	for _, x := range policies {
+		resolved.Domains = append(resolved.Domains, x.Spec.Domains...)
+		resolved.CatchAll = resolved.CatchAll || x.Spec.AllowCatchAll
+		resolved.Sources = append(resolved.Sources, &policySource{
+			Kind:     "IngressDomainPolicy",
+			Name:     x.Name,
+			Revision: x.ResourceVersion,
+			Domains:  x.Spec.Domains,
+		})
+	}
+
+	disabled := config.DisableNamespaceAnnotations
+	annotated := false
+	if !disabled {
+		annotations := namespace.GetAnnotations()
+
+		whitelist, found := annotations[DomainWhitelistAnnotation]
+		if strings.TrimSpace(whitelist) != "" {
+			entries := splitWhitelist(whitelist)
+			resolved.Domains = append(resolved.Domains, entries...)
+			resolved.Sources = append(resolved.Sources, &policySource{
+				Kind:     "Namespace",
+				Name:     namespace.Name,
+				Revision: namespace.ResourceVersion,
+				Domains:  entries,
+			})
+		}
+		resolved.CatchAll = resolved.CatchAll || annotations[CatchAllAnnotation] == "true"
+		annotated = found
+	}
+
+	// @check the namespace has a policy, falling back to the cluster default domains
+	if len(policies) == 0 && !annotated {
+		defaults := cluster.DefaultDomains
+		switch {
+		case len(defaults) > 0:
+			resolved.Domains = append(resolved.Domains, defaults...)
+			resolved.Sources = append(resolved.Sources, &policySource{
+				Kind:     "ConfigMap",
+				Name:     config.PolicyConfigMap,
+				Revision: cluster.Revision,
+				Domains:  defaults,
+			})
+		case disabled:
+			return nil, errors.New("namespace has no ingress domain policy")
+		default:
+			return nil, fmt.Errorf("namespace has no whitelist annotation: %s", DomainWhitelistAnnotation)
+		}
+	}
+
+	// @check the whitelist is not empty
+	if len(resolved.Domains) == 0 {
+		return nil, errors.New("namespace whitelist is empty")
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPolicySelects(t *testing.T) {
+	namespace := &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test",
+			Labels: map[string]string{"team": "a"},
+		},
+	}
+	cs := []struct {
+		Spec     IngressDomainPolicySpec
+		Expected bool
+	}{
+		{Spec: IngressDomainPolicySpec{}},
+		{Spec: IngressDomainPolicySpec{Namespaces: []string{"other"}}},
+		{Spec: IngressDomainPolicySpec{Namespaces: []string{"other", "test"}}, Expected: true},
+		{Spec: IngressDomainPolicySpec{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}}, Expected: true},
+		{Spec: IngressDomainPolicySpec{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}}}},
+		{Spec: IngressDomainPolicySpec{NamespaceSelector: &metav1.LabelSelector{}}},
+		{Spec: IngressDomainPolicySpec{Namespaces: []string{"test"}, NamespaceSelector: &metav1.LabelSelector{}}, Expected: true},
+	}
+	for i, c := range cs {
+		policy := &IngressDomainPolicy{Spec: c.Spec}
+		selected, err := policy.selects(namespace)
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Expected, selected, "case %d", i)
+	}
+}
+
+func TestToDomainPolicy(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "ingress-admission.acp.homeoffice.gov.uk/v1alpha1",
+		"kind":       "IngressDomainPolicy",
+		"metadata":   map[string]interface{}{"name": "team-a"},
+		"spec": map[string]interface{}{
+			"namespaces":    []interface{}{"test"},
+			"domains":       []interface{}{"*.team-a.example.com"},
+			"allowCatchAll": true,
+		},
+	}}
+	obj, err := toDomainPolicy(u)
+	require.NoError(t, err)
+	policy, ok := obj.(*IngressDomainPolicy)
+	require.True(t, ok)
+	assert.Equal(t, "team-a", policy.Name)
+	assert.Equal(t, IngressDomainPolicySpec{
+		Namespaces:    []string{"test"},
+		Domains:       []string{"*.team-a.example.com"},
+		AllowCatchAll: true,
+	}, policy.Spec)
+}
+
+func TestResolvePolicy(t *testing.T) {
+	c := newFakeController()
+	c.service.policies = newFakePolicyStore(t,
+		&IngressDomainPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "b"},
+			Spec:       IngressDomainPolicySpec{Namespaces: []string{"test"}, Domains: []string{"b.example.com"}},
+		},
+		&IngressDomainPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "a"},
+			Spec:       IngressDomainPolicySpec{Namespaces: []string{"test"}, Domains: []string{"a.example.com"}, AllowCatchAll: true},
+		},
+	)
+
+	namespace := &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "c.example.com"},
+		},
+	}
+	policy, err := c.service.resolvePolicy(namespace, c.service.config, c.service.getClusterPolicy())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com", "b.example.com", "c.example.com"}, policy.Domains)
+	assert.True(t, policy.CatchAll)
+	require.Len(t, policy.Sources, 3)
+	assert.Equal(t, "b", policy.source("b.example.com").Name)
+	assert.Equal(t, "Namespace", policy.source("c.example.com").Kind)
+	assert.Nil(t, policy.source("d.example.com"))
+
+	// @check the entries of a domain policy are never split on a comma
+	c.service.policies.Add(&IngressDomainPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "c"},
+		Spec:       IngressDomainPolicySpec{Namespaces: []string{"test"}, Domains: []string{"re:^pr-[0-9]{1,3}\\.example\\.com$"}},
+	})
+	policy, err = c.service.resolvePolicy(namespace, c.service.config, c.service.getClusterPolicy())
+	require.NoError(t, err)
+	assert.Equal(t, "c", policy.source("re:^pr-[0-9]{1,3}\\.example\\.com$").Name)
+	c.service.policies.Delete(&IngressDomainPolicy{ObjectMeta: metav1.ObjectMeta{Name: "c"}})
+
+	c.service.config.DisableNamespaceAnnotations = true
+	policy, err = c.service.resolvePolicy(namespace, c.service.config, c.service.getClusterPolicy())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, policy.Domains)
+
+	_, err = c.service.resolvePolicy(&api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}}, c.service.config, c.service.getClusterPolicy())
+	require.Error(t, err)
+	assert.Equal(t, "namespace has no ingress domain policy", err.Error())
+}
+
+func TestResolvePolicyBadSelector(t *testing.T) {
+	c := newFakeController()
+	c.service.policies = newFakePolicyStore(t, &IngressDomainPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "bad"},
+		Spec: IngressDomainPolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Bad"}},
+			},
+		},
+	})
+
+	_, err := c.service.resolvePolicy(&api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}, c.service.config, c.service.getClusterPolicy())
+	require.Error(t, err)
+	assert.Equal(t, "unable to evaluate domain policies", err.Error())
+}
+
+func TestDomainPolicyReview(t *testing.T) {
+	c := newFakeController()
+	c.service.config.DisableNamespaceAnnotations = true
+	c.service.policies = newFakePolicyStore(t, &IngressDomainPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "team"},
+		Spec: IngressDomainPolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			Domains:           []string{"*.team-a.example.com"},
+		},
+	})
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test",
+			Labels: map[string]string{"team": "a"},
+			// @note: the annotation should be ignored as they have been disabled
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.bank.example.com"},
+		},
+	}, metav1.CreateOptions{})
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("site.team-a.example.com"),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("www.bank.example.com"),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "hostname: www.bank.example.com is not permitted by namespace policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func newFakePolicyStore(t *testing.T, policies ...*IngressDomainPolicy) cache.Store {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, x := range policies {
+		require.NoError(t, store.Add(x))
+	}
+
+	return store
+}
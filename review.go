@@ -0,0 +1,153 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	admission "k8s.io/api/admission/v1"
+	authentication "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// admissionV1 is the api version of the admission.k8s.io/v1 review
+	admissionV1 = "admission.k8s.io/v1"
+	// admissionV1beta1 is the api version of the admission.k8s.io/v1beta1 review
+	admissionV1beta1 = "admission.k8s.io/v1beta1"
+	// admissionV1alpha1 is the api version of the legacy external admission review
+	admissionV1alpha1 = "admission.k8s.io/v1alpha1"
+)
+
+// review is a version agnostic wrapper around an incoming admission review
+type review struct {
+	// apiVersion is the version of the review we received
+	apiVersion string
+	// legacy is the original review when received as v1alpha1
+	legacy *legacyAdmissionReview
+	// request is the normalized admission request
+	request *admission.AdmissionRequest
+}
+
+// decodeReview detects the api version of the review and normalizes the request
+func decodeReview(content []byte) (*review, error) {
+	meta := &metav1.TypeMeta{}
+	if err := json.Unmarshal(content, meta); err != nil {
+		return nil, err
+	}
+
+	switch meta.APIVersion {
+	case admissionV1, admissionV1beta1:
+		// the v1beta1 and v1 reviews share the same wire format
+		r := &admission.AdmissionReview{}
+		if err := json.Unmarshal(content, r); err != nil {
+			return nil, err
+		}
+		if r.Request == nil {
+			return nil, errors.New("admission review has no request")
+		}
+
+		return &review{apiVersion: meta.APIVersion, request: r.Request}, nil
+	case admissionV1alpha1:
+		r := &legacyAdmissionReview{}
+		if err := json.Unmarshal(content, r); err != nil {
+			return nil, err
+		}
+
+		return &review{
+			apiVersion: meta.APIVersion,
+			legacy:     r,
+			request: &admission.AdmissionRequest{
+				Kind:        r.Spec.Kind,
+				Name:        r.Spec.Name,
+				Namespace:   r.Spec.Namespace,
+				Object:      r.Spec.Object,
+				OldObject:   r.Spec.OldObject,
+				Operation:   admission.Operation(r.Spec.Operation),
+				Resource:    r.Spec.Resource,
+				SubResource: r.Spec.SubResource,
+				UserInfo:    r.Spec.UserInfo,
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported admission review version: %q", meta.APIVersion)
+}
+
+// encode returns the response in the wire format of the incoming review
+func (r *review) encode(response *admission.AdmissionResponse) interface{} {
+	if r.legacy != nil {
+		r.legacy.Status = legacyAdmissionReviewStatus{
+			Allowed: response.Allowed,
+			Result:  response.Result,
+		}
+
+		return r.legacy
+	}
+	response.UID = r.request.UID
+
+	return &admission.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: r.apiVersion,
+			Kind:       "AdmissionReview",
+		},
+		Response: response,
+	}
+}
+
+// legacyAdmissionReview is the admission.k8s.io/v1alpha1 review sent by the older
+// external admission hooks; the type was dropped from k8s.io/api so we carry our own
+type legacyAdmissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	// Spec describes the attributes for the admission request
+	Spec legacyAdmissionReviewSpec `json:"spec,omitempty"`
+	// Status is filled in by the webhook and indicates whether the request should be admitted
+	Status legacyAdmissionReviewStatus `json:"status,omitempty"`
+}
+
+// legacyAdmissionReviewSpec describes the admission.Attributes for the admission request
+type legacyAdmissionReviewSpec struct {
+	// Kind is the type of object being manipulated
+	Kind metav1.GroupVersionKind `json:"kind,omitempty"`
+	// Object is the object from the incoming request prior to default values being applied
+	Object runtime.RawExtension `json:"object,omitempty"`
+	// OldObject is the existing object, only populated for UPDATE requests
+	OldObject runtime.RawExtension `json:"oldObject,omitempty"`
+	// Operation is the operation being performed
+	Operation string `json:"operation,omitempty"`
+	// Name is the name of the object as presented in the request
+	Name string `json:"name,omitempty"`
+	// Namespace is the namespace associated with the request (if any)
+	Namespace string `json:"namespace,omitempty"`
+	// Resource is the name of the resource being requested
+	Resource metav1.GroupVersionResource `json:"resource,omitempty"`
+	// SubResource is the name of the subresource being requested
+	SubResource string `json:"subResource,omitempty"`
+	// UserInfo is information about the requesting user
+	UserInfo authentication.UserInfo `json:"userInfo,omitempty"`
+}
+
+// legacyAdmissionReviewStatus describes the status of the admission request
+type legacyAdmissionReviewStatus struct {
+	// Allowed indicates whether or not the admission request was permitted
+	Allowed bool `json:"allowed"`
+	// Result contains extra details into why an admission request was denied
+	Result *metav1.Status `json:"status,omitempty"`
+}
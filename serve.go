@@ -17,20 +17,39 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
-	admission "k8s.io/api/admission/v1alpha1"
 )
 
+// maxReviewSize is the largest admission review we are willing to read, matching the apiserver request limit
+const maxReviewSize = 3 * 1024 * 1024
+
 // reviewHandler is responsible for handling the incoming admission request review
 func (c *controller) reviewHandler(ctx echo.Context) error {
-	review := &admission.AdmissionReview{}
+	body := http.MaxBytesReader(ctx.Response(), ctx.Request().Body, maxReviewSize)
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("unable to read the request")
 
-	// @step: we need to unmarshal the review
-	if err := ctx.Bind(review); err != nil {
+		// @check if the review exceeded the size limit
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return ctx.NoContent(http.StatusRequestEntityTooLarge)
+		}
+
+		return ctx.NoContent(http.StatusBadRequest)
+	}
+
+	// @step: we need to unmarshal the review, whichever version it is
+	review, err := decodeReview(content)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err.Error(),
 		}).Error("unable to decode the request")
@@ -39,13 +58,14 @@ func (c *controller) reviewHandler(ctx echo.Context) error {
 	}
 
 	// @step: apply the policy against the review
-	if err := c.admit(review); err != nil {
+	response, err := c.admit(review.request)
+	if err != nil {
 		log.WithFields(log.Fields{"error": err.Error()}).Error("unable to apply the policy")
 
 		return ctx.NoContent(http.StatusInternalServerError)
 	}
 
-	return ctx.JSON(http.StatusOK, review)
+	return ctx.JSON(http.StatusOK, review.encode(response))
 }
 
 // healthHandler is just a health endpoint for the kubelet to call
@@ -53,6 +73,47 @@ func (c *controller) healthHandler(ctx echo.Context) error {
 	return ctx.String(http.StatusOK, "OK\n")
 }
 
+// readyHandler indicates if the controller is ready to serve requests, i.e. the caches have synced
+func (c *controller) readyHandler(ctx echo.Context) error {
+	if !c.isReady() {
+		return ctx.String(http.StatusServiceUnavailable, "NOT READY\n")
+	}
+
+	return ctx.String(http.StatusOK, "OK\n")
+}
+
+// controllerStatus is the status of the controller
+type controllerStatus struct {
+	// Ready indicates the caches have synced
+	Ready bool `json:"ready"`
+	// Version is the version of the controller
+	Version string `json:"version"`
+	// Certificate is the certificate being served
+	Certificate *certificateStatus `json:"certificate,omitempty"`
+	// Violations is a count of the policy violations admitted by enforcement mode
+	Violations map[string]int64 `json:"violations,omitempty"`
+}
+
+// statusHandler returns the status of the controller, including the expiry of the certificate
+func (c *controller) statusHandler(ctx echo.Context) error {
+	status := &controllerStatus{Ready: c.isReady(), Version: Version, Violations: c.getViolations()}
+	if c.certificates != nil {
+		status.Certificate = c.certificates.status()
+	}
+
+	return ctx.JSON(http.StatusOK, status)
+}
+
+// auditHandler returns the report from the last audit of the existing ingresses
+func (c *controller) auditHandler(ctx echo.Context) error {
+	report := c.getAuditReport()
+	if report == nil {
+		return ctx.String(http.StatusNotFound, "NO AUDIT\n")
+	}
+
+	return ctx.JSON(http.StatusOK, report)
+}
+
 // versionHandler is responsible for handling the version handler
 func (c *controller) versionHandler(ctx echo.Context) error {
 	return ctx.String(http.StatusOK, fmt.Sprintf("%s\n", Version))
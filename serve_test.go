@@ -17,20 +17,28 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
-	admission "k8s.io/api/admission/v1alpha1"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1"
 	authentication "k8s.io/api/authentication/v1"
 	api "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
 	fakeHostname = "rohith.dev.homeoffice.gov.uk"
+	fakeUID      = "c3a3d5a1-1f3b-4f8e-9d6a-0d0a2b5f3e21"
 )
 
 func TestIngressNoNamespace(t *testing.T) {
@@ -39,7 +47,7 @@ func TestIngressNoNamespace(t *testing.T) {
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview(fakeHostname),
-			ExpectedStatus: &admission.AdmissionReviewStatus{
+			ExpectedStatus: &legacyAdmissionReviewStatus{
 				Result: &metav1.Status{
 					Code:    http.StatusForbidden,
 					Message: "unable to get namespace",
@@ -55,18 +63,18 @@ func TestIngressNoNamespace(t *testing.T) {
 
 func TestIngressNoAnnotation(t *testing.T) {
 	c := newFakeController()
-	c.service.client.CoreV1().Namespaces().Create(&api.Namespace{
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "test",
 		},
-	})
+	}, metav1.CreateOptions{})
 
 	requests := []request{
 		{
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview(fakeHostname),
-			ExpectedStatus: &admission.AdmissionReviewStatus{
+			ExpectedStatus: &legacyAdmissionReviewStatus{
 				Result: &metav1.Status{
 					Code:    http.StatusForbidden,
 					Message: "namespace has no whitelist annotation: ingress-admission.acp.homeoffice.gov.uk/domains",
@@ -82,19 +90,19 @@ func TestIngressNoAnnotation(t *testing.T) {
 
 func TestWhitelistEmpty(t *testing.T) {
 	c := newFakeController()
-	c.service.client.CoreV1().Namespaces().Create(&api.Namespace{
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        "test",
 			Annotations: map[string]string{DomainWhitelistAnnotation: ""},
 		},
-	})
+	}, metav1.CreateOptions{})
 
 	requests := []request{
 		{
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview(fakeHostname),
-			ExpectedStatus: &admission.AdmissionReviewStatus{
+			ExpectedStatus: &legacyAdmissionReviewStatus{
 				Result: &metav1.Status{
 					Code:    http.StatusForbidden,
 					Message: "namespace whitelist is empty",
@@ -111,17 +119,17 @@ func TestWhitelistEmpty(t *testing.T) {
 func TestIgnoredNamespace(t *testing.T) {
 	c := newFakeController()
 	c.service.config.IgnoreNamespaces = []string{"test"}
-	c.service.client.CoreV1().Namespaces().Create(&api.Namespace{
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "test",
 		},
-	})
+	}, metav1.CreateOptions{})
 	requests := []request{
 		{
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview("rohith.test.svc.cluster.local"),
-			ExpectedStatus:  &admission.AdmissionReviewStatus{Allowed: true},
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
 			ExpectedCode:    http.StatusOK,
 		},
 	}
@@ -131,17 +139,17 @@ func TestIgnoredNamespace(t *testing.T) {
 func TestIgnoredNamespaceBad(t *testing.T) {
 	c := newFakeController()
 	c.service.config.IgnoreNamespaces = []string{"other_namespae"}
-	c.service.client.CoreV1().Namespaces().Create(&api.Namespace{
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "test",
 		},
-	})
+	}, metav1.CreateOptions{})
 	requests := []request{
 		{
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview("rohith.test.svc.cluster.local"),
-			ExpectedStatus: &admission.AdmissionReviewStatus{
+			ExpectedStatus: &legacyAdmissionReviewStatus{
 				Result: &metav1.Status{
 					Code:    http.StatusForbidden,
 					Message: "namespace has no whitelist annotation: ingress-admission.acp.homeoffice.gov.uk/domains",
@@ -157,32 +165,117 @@ func TestIgnoredNamespaceBad(t *testing.T) {
 
 func TestNamespaceWhitelist(t *testing.T) {
 	c := newFakeController()
-	c.service.client.CoreV1().Namespaces().Create(&api.Namespace{
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        "test",
 			Annotations: map[string]string{DomainWhitelistAnnotation: "*.test.svc.cluster.local"},
 		},
-	})
+	}, metav1.CreateOptions{})
 	requests := []request{
 		{
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview("rohith.test.svc.cluster.local"),
-			ExpectedStatus:  &admission.AdmissionReviewStatus{Allowed: true},
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
 			ExpectedCode:    http.StatusOK,
 		},
 		{
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview("site.test.svc.cluster.local"),
-			ExpectedStatus:  &admission.AdmissionReviewStatus{Allowed: true},
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
 			ExpectedCode:    http.StatusOK,
 		},
 		{
 			URI:             "/",
 			Method:          http.MethodPost,
 			AdmissionReview: createFakeIngressReview("bad.test.test.svc.cluster.local"),
-			ExpectedStatus: &admission.AdmissionReviewStatus{
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "hostname: bad.test.test.svc.cluster.local is not permitted by namespace policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestAdmissionReviewV1(t *testing.T) {
+	c := newFakeController()
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.test.svc.cluster.local"},
+		},
+	}, metav1.CreateOptions{})
+
+	for _, version := range []string{admissionV1, admissionV1beta1} {
+		requests := []request{
+			{
+				URI:              "/",
+				Method:           http.MethodPost,
+				AdmissionReview:  createFakeIngressReviewV1(version, "rohith.test.svc.cluster.local"),
+				ExpectedResponse: &admission.AdmissionResponse{UID: fakeUID, Allowed: true},
+				ExpectedCode:     http.StatusOK,
+			},
+			{
+				URI:             "/",
+				Method:          http.MethodPost,
+				AdmissionReview: createFakeIngressReviewV1(version, "bad.test.test.svc.cluster.local"),
+				ExpectedResponse: &admission.AdmissionResponse{
+					UID: fakeUID,
+					Result: &metav1.Status{
+						Code:    http.StatusForbidden,
+						Message: "hostname: bad.test.test.svc.cluster.local is not permitted by namespace policy",
+						Reason:  metav1.StatusReasonForbidden,
+						Status:  metav1.StatusFailure,
+					},
+				},
+				ExpectedCode: http.StatusOK,
+			},
+		}
+		c.runTests(t, requests)
+	}
+}
+
+func TestNetworkingIngressReview(t *testing.T) {
+	c := newFakeController()
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.test.svc.cluster.local"},
+		},
+	}, metav1.CreateOptions{})
+
+	good := createFakeIngressReviewV1(admissionV1, "")
+	good.Request.Kind = metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	good.Request.Object.Raw, _ = json.Marshal(createFakeNetworkingIngress("rohith.test.svc.cluster.local"))
+
+	bad := createFakeIngressReviewV1(admissionV1, "")
+	bad.Request.Kind = metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+	bad.Request.Object.Raw, _ = json.Marshal(createFakeNetworkingIngress("bad.test.test.svc.cluster.local"))
+
+	unsupported := createFakeIngressReviewV1(admissionV1, "")
+	unsupported.Request.Kind = metav1.GroupVersionKind{Group: "networking.k8s.io", Version: "v2", Kind: "Ingress"}
+
+	requests := []request{
+		{
+			URI:              "/",
+			Method:           http.MethodPost,
+			AdmissionReview:  good,
+			ExpectedResponse: &admission.AdmissionResponse{UID: fakeUID, Allowed: true},
+			ExpectedCode:     http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: bad,
+			ExpectedResponse: &admission.AdmissionResponse{
+				UID: fakeUID,
 				Result: &metav1.Status{
 					Code:    http.StatusForbidden,
 					Message: "hostname: bad.test.test.svc.cluster.local is not permitted by namespace policy",
@@ -192,10 +285,405 @@ func TestNamespaceWhitelist(t *testing.T) {
 			},
 			ExpectedCode: http.StatusOK,
 		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: unsupported,
+			ExpectedResponse: &admission.AdmissionResponse{
+				UID: fakeUID,
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "unable to decode ingress spec: unsupported ingress version: networking.k8s.io/v2",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestDenyDomains(t *testing.T) {
+	c := newFakeController()
+	c.service.config.IgnoreNamespaces = []string{"ignored"}
+	c.service.config.DenyDomains = []DenyDomain{
+		{Domain: "*.internal.example.com", Namespaces: []string{"platform"}},
+	}
+	for _, name := range []string{"test", "platform"} {
+		c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{DomainWhitelistAnnotation: "**.example.com"},
+			},
+		}, metav1.CreateOptions{})
+	}
+
+	permitted := createFakeIngressReview("api.internal.example.com")
+	permitted.Spec.Namespace = "platform"
+
+	ignored := createFakeIngressReview("api.internal.example.com")
+	ignored.Spec.Namespace = "ignored"
+
+	tls := createFakeIngress("site.example.com")
+	tls.Spec.TLS[0].Hosts = []string{"vault.internal.example.com"}
+
+	denied := &legacyAdmissionReviewStatus{
+		Result: &metav1.Status{
+			Code:    http.StatusForbidden,
+			Message: "hostname: api.internal.example.com is denied by cluster policy",
+			Reason:  metav1.StatusReasonForbidden,
+			Status:  metav1.StatusFailure,
+		},
+	}
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("api.internal.example.com"),
+			ExpectedStatus:  denied,
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: ignored,
+			ExpectedStatus:  denied,
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeReviewFromIngress(tls),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "hostname: vault.internal.example.com is denied by cluster policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: permitted,
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("site.example.com"),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestNamespaceWhitelistPatterns(t *testing.T) {
+	c := newFakeController()
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: `re:^pr-[0-9]+\.preview\.example\.com$`},
+		},
+	}, metav1.CreateOptions{})
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "bad",
+			Annotations: map[string]string{DomainWhitelistAnnotation: `re:pr-[0-9]+`},
+		},
+	}, metav1.CreateOptions{})
+
+	bad := createFakeIngressReview("pr-1.preview.example.com")
+	bad.Spec.Namespace = "bad"
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("pr-12.preview.example.com"),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("pr-x.preview.example.com"),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "hostname: pr-x.preview.example.com is not permitted by namespace policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: bad,
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: `namespace whitelist is invalid: invalid pattern: "re:pr-[0-9]+", pattern must be anchored with ^ and $`,
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
 	}
 	c.runTests(t, requests)
 }
 
+func TestTLSHostnameWhitelist(t *testing.T) {
+	c := newFakeController()
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.test.svc.cluster.local"},
+		},
+	}, metav1.CreateOptions{})
+
+	good := createFakeIngress("rohith.test.svc.cluster.local")
+	good.Spec.TLS[0].Hosts = append(good.Spec.TLS[0].Hosts, "site.test.svc.cluster.local")
+
+	bad := createFakeIngress("rohith.test.svc.cluster.local")
+	bad.Spec.TLS = append(bad.Spec.TLS, extensions.IngressTLS{
+		Hosts:      []string{"www.bank.example.com"},
+		SecretName: "bank",
+	})
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeReviewFromIngress(good),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeReviewFromIngress(bad),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "tls hostname: www.bank.example.com is not permitted by namespace policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestCatchAllIngress(t *testing.T) {
+	c := newFakeController()
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.test.svc.cluster.local"},
+		},
+	}, metav1.CreateOptions{})
+
+	noHost := createFakeIngress("rohith.test.svc.cluster.local")
+	noHost.Spec.Rules = append(noHost.Spec.Rules, extensions.IngressRule{})
+
+	backendOnly := createFakeIngress("rohith.test.svc.cluster.local")
+	backendOnly.Spec.TLS = nil
+	backendOnly.Spec.Rules = nil
+	backendOnly.Spec.Backend = &extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeReviewFromIngress(noHost),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "rules without a hostname are not permitted by namespace policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeReviewFromIngress(backendOnly),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "default backend only ingresses are not permitted by namespace policy",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestCatchAllIngressPermitted(t *testing.T) {
+	c := newFakeController()
+	c.service.client.CoreV1().Namespaces().Create(context.TODO(), &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Annotations: map[string]string{
+				CatchAllAnnotation:        "true",
+				DomainWhitelistAnnotation: "*.test.svc.cluster.local",
+			},
+		},
+	}, metav1.CreateOptions{})
+
+	noHost := createFakeIngress("rohith.test.svc.cluster.local")
+	noHost.Spec.Rules = append(noHost.Spec.Rules, extensions.IngressRule{})
+
+	backendOnly := createFakeIngress("rohith.test.svc.cluster.local")
+	backendOnly.Spec.TLS = nil
+	backendOnly.Spec.Rules = nil
+	backendOnly.Spec.Backend = &extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeReviewFromIngress(noHost),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeReviewFromIngress(backendOnly),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+}
+
+func TestHostnameClaimedByNamespace(t *testing.T) {
+	claimed := createFakeNetworkingIngress("shop.apps.example.com")
+	claimed.Namespace = "other"
+
+	c := newFakeController()
+	c.service.client = fake.NewSimpleClientset(claimed, &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+		},
+	})
+	require.NoError(t, c.service.startInformers())
+	defer c.service.stop()
+	waitForSync(t, c.service)
+
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("shop.apps.example.com"),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "hostname: shop.apps.example.com is already claimed by namespace: other",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("site.apps.example.com"),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+	}
+	c.runTests(t, requests)
+
+	// @check the host is permitted when marked as shared
+	c.service.config.SharedHosts = []string{"shop.apps.example.com"}
+	c.runTests(t, []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("shop.apps.example.com"),
+			ExpectedStatus:  &legacyAdmissionReviewStatus{Allowed: true},
+			ExpectedCode:    http.StatusOK,
+		},
+	})
+}
+
+func TestHostnameOwnerLookupFailure(t *testing.T) {
+	c := newFakeController()
+	c.service.client = fake.NewSimpleClientset(&api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{DomainWhitelistAnnotation: "*.apps.example.com"},
+		},
+	})
+	// @note: an indexer without the host index fails every lookup by hostname
+	c.service.ingresses = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	c.runTests(t, []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: createFakeIngressReview("shop.apps.example.com"),
+			ExpectedStatus: &legacyAdmissionReviewStatus{
+				Result: &metav1.Status{
+					Code:    http.StatusForbidden,
+					Message: "unable to check if hostname: shop.apps.example.com is claimed by another namespace",
+					Reason:  metav1.StatusReasonForbidden,
+					Status:  metav1.StatusFailure,
+				},
+			},
+			ExpectedCode: http.StatusOK,
+		},
+	})
+}
+
+func TestAdmissionReviewBadVersion(t *testing.T) {
+	review := createFakeIngressReviewV1("admission.k8s.io/v2", fakeHostname)
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: review,
+			ExpectedCode:    http.StatusBadRequest,
+		},
+	}
+	newFakeController().runTests(t, requests)
+}
+
+func TestAdmissionReviewTooLarge(t *testing.T) {
+	review := createFakeIngressReviewV1(admissionV1, fakeHostname)
+	review.Request.Object.Raw = []byte(`"` + strings.Repeat("a", maxReviewSize) + `"`)
+	requests := []request{
+		{
+			URI:             "/",
+			Method:          http.MethodPost,
+			AdmissionReview: review,
+			ExpectedCode:    http.StatusRequestEntityTooLarge,
+		},
+	}
+	newFakeController().runTests(t, requests)
+}
+
 func TestVersionHandler(t *testing.T) {
 	requests := []request{
 		{
@@ -240,8 +728,8 @@ func createFakeIngress(hostname string) *extensions.Ingress {
 			},
 		},
 		Status: extensions.IngressStatus{
-			LoadBalancer: api.LoadBalancerStatus{
-				Ingress: []api.LoadBalancerIngress{
+			LoadBalancer: extensions.IngressLoadBalancerStatus{
+				Ingress: []extensions.IngressLoadBalancerIngress{
 					{
 						IP:       "",
 						Hostname: "",
@@ -252,7 +740,42 @@ func createFakeIngress(hostname string) *extensions.Ingress {
 	}
 }
 
-func createFakeIngressReview(hostname string) *admission.AdmissionReview {
+func createFakeIngressReview(hostname string) *legacyAdmissionReview {
+	return createFakeReviewFromIngress(createFakeIngress(hostname))
+}
+
+func createFakeReviewFromIngress(ingress *extensions.Ingress) *legacyAdmissionReview {
+	// we need to encode the ingress
+	content, _ := json.Marshal(ingress)
+
+	return &legacyAdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1alpha1",
+		},
+		Spec: legacyAdmissionReviewSpec{
+			Kind: metav1.GroupVersionKind{
+				Group:   "extensions",
+				Version: "v1beta1",
+				Kind:    "Ingress",
+			},
+			Object:    runtime.RawExtension{Raw: content},
+			Operation: string(admission.Create),
+			Name:      "test",
+			Namespace: "test",
+			Resource: metav1.GroupVersionResource{
+				Group:    "extensions",
+				Version:  "v1beta1",
+				Resource: "ingresses",
+			},
+			UserInfo: authentication.UserInfo{
+				Username: "admin",
+			},
+		},
+	}
+}
+
+func createFakeIngressReviewV1(apiVersion, hostname string) *admission.AdmissionReview {
 	ingress := createFakeIngress(hostname)
 	// we need to encode the ingress
 	content, _ := json.Marshal(ingress)
@@ -260,9 +783,10 @@ func createFakeIngressReview(hostname string) *admission.AdmissionReview {
 	return &admission.AdmissionReview{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "AdmissionReview",
-			APIVersion: "admission.k8s.io/v1alpha1",
+			APIVersion: apiVersion,
 		},
-		Spec: admission.AdmissionReviewSpec{
+		Request: &admission.AdmissionRequest{
+			UID: types.UID(fakeUID),
 			Kind: metav1.GroupVersionKind{
 				Group:   "extensions",
 				Version: "v1beta1",
@@ -2,45 +2,130 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 
-	"k8s.io/client-go/kubernetes"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// hasDomain checks the domain exists with in the whitelist
-// e.g hostname.namespace.svc.cluster.local or *.namespace.svc.cluster.local
+// hasDomain checks the hostname is permitted by any of the entries in the whitelist, where an entry is
+// either an exact hostname, a single label wildcard (*.domain.com), a multi label wildcard (**.domain.com)
+// or the apex plus any subdomain (.domain.com); the comparison is case insensitive and ignores any
+// whitespace or trailing dots
 func hasDomain(hostname string, whitelist []string) bool {
-	for _, domain := range whitelist {
-		// @step: we need to remove whitelist
-		domain = strings.Replace(domain, " ", "", -1)
-		wildcard := strings.HasPrefix(domain, "*.")
-		switch wildcard {
-		case true:
-			// a quick hacky check to ensure the you don't have subdomains
-			size := len(strings.Split(domain, "."))
-			hostSize := len(strings.Split(hostname, "."))
-			if size != hostSize {
-				return false
-			}
+	_, found := matchingDomain(hostname, whitelist)
 
-			domain = strings.TrimPrefix(domain, "*")
-			if strings.HasSuffix(hostname, domain) {
-				return true
-			}
-		default:
-			// @check there is an exact match between hostname and whitelist
-			if hostname == domain {
-				return true
+	return found
+}
+
+// matchingDomain returns the first entry in the whitelist permitting the hostname
+func matchingDomain(hostname string, whitelist []string) (string, bool) {
+	hostname = normalizeDomain(hostname)
+	if hostname == "" {
+		return "", false
+	}
+
+	for _, x := range whitelist {
+		entry := normalizeDomain(x)
+		if entry == "" {
+			continue
+		}
+		if matchDomain(hostname, entry) {
+			return x, true
+		}
+	}
+
+	return "", false
+}
+
+// matchDomain checks if a normalized hostname is matched by a normalized whitelist entry
+func matchDomain(hostname, entry string) bool {
+	switch {
+	case strings.HasPrefix(entry, "**."):
+		// @check the hostname has one or more labels in front of the domain
+		suffix := strings.TrimPrefix(entry, "**")
+
+		return len(hostname) > len(suffix) && strings.HasSuffix(hostname, suffix)
+	case strings.HasPrefix(entry, "*."):
+		// @check the hostname has exactly one label in front of the domain
+		suffix := strings.TrimPrefix(entry, "*")
+		if !strings.HasSuffix(hostname, suffix) {
+			return false
+		}
+		label := strings.TrimSuffix(hostname, suffix)
+
+		return label != "" && !strings.Contains(label, ".")
+	case strings.HasPrefix(entry, "."):
+		// @check the hostname is the apex or any subdomain of it
+		return hostname == strings.TrimPrefix(entry, ".") || strings.HasSuffix(hostname, entry)
+	}
+
+	return hostname == entry
+}
+
+// normalizeDomain removes any whitespace and trailing dot and lower cases the domain
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	return strings.TrimSuffix(domain, ".")
+}
+
+// parseDenyDomains parses the deny domains from the command line, each in the format
+// DOMAIN[:NAMESPACE:NAMESPACE...], i.e. *.internal.example.com:platform:kube-system
+func parseDenyDomains(values []string) ([]DenyDomain, error) {
+	var list []DenyDomain
+	for _, x := range values {
+		items := strings.Split(strings.TrimSpace(x), ":")
+		if items[0] == "" {
+			return nil, fmt.Errorf("deny domain: %q has no domain", x)
+		}
+		deny := DenyDomain{Domain: items[0]}
+		for _, namespace := range items[1:] {
+			if namespace == "" {
+				return nil, fmt.Errorf("deny domain: %q has an empty namespace", x)
 			}
+			deny.Namespaces = append(deny.Namespaces, namespace)
+		}
+		list = append(list, deny)
+	}
+
+	return list, nil
+}
+
+// isDeniedDomain checks if the hostname is on the deny list for the namespace
+func isDeniedDomain(hostname, namespace string, denied []DenyDomain) bool {
+	for _, x := range denied {
+		if !hasDomain(hostname, []string{x.Domain}) {
+			continue
+		}
+		if !containedIn(namespace, x.Namespaces) {
+			return true
 		}
 	}
 
 	return false
 }
 
-// getTLSConfig builds the TLS configuration from the options
-func getTLSConfig(c *Config) (*tls.Config, error) {
+// containedIn checks if the value is in the list
+func containedIn(value string, list []string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getTLSConfig builds the TLS configuration from the options, serving the certificate from the reloader
+func getTLSConfig(c *Config, certificates *certificateReloader) (*tls.Config, error) {
 	cfg := &tls.Config{
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
@@ -48,24 +133,146 @@ func getTLSConfig(c *Config) (*tls.Config, error) {
 		ClientAuth:               tls.NoClientCert,
 	}
 
-	// @step: load the server certificates
-	if c.TLSCert != "" && c.TLSKey != "" {
-		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	// @step: serve the certificates from the reloader
+	if certificates != nil {
+		cfg.GetCertificate = certificates.GetCertificate
+	}
+
+	// @step: require and verify the client certificates if mutual tls is enabled
+	if c.EnableClientTLS {
+		content, err := ioutil.ReadFile(c.TLSCA)
 		if err != nil {
 			return nil, err
 		}
-		cfg.Certificates = []tls.Certificate{cert}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in the ca: %s", c.TLSCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+		if len(c.ClientNames) > 0 {
+			cfg.VerifyPeerCertificate = verifyClientName(c.ClientNames)
+		}
 	}
 
 	return cfg, nil
 }
 
-// getKubernetesClient returns a kubernetes api client for us
-func getKubernetesClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
+// verifyClientName returns a verifier checking the client certificate carries one of the names,
+// either as the common name or a subject alternative name
+func verifyClientName(names []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			if len(chain) == 0 {
+				continue
+			}
+			cert := chain[0]
+			if containedIn(cert.Subject.CommonName, names) {
+				return nil
+			}
+			for _, x := range cert.DNSNames {
+				if containedIn(x, names) {
+					return nil
+				}
+			}
+			for _, x := range cert.EmailAddresses {
+				if containedIn(x, names) {
+					return nil
+				}
+			}
+			for _, x := range cert.URIs {
+				if containedIn(x.String(), names) {
+					return nil
+				}
+			}
+		}
+
+		return errors.New("client certificate is not permitted")
+	}
+}
+
+// watchFiles calls the handler whenever any of the files change, until the stop channel is closed; the
+// directories are watched rather than the files as a mounted configmap or secret is updated by swapping
+// a symlink
+func watchFiles(paths []string, stopCh <-chan struct{}, handler func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, x := range paths {
+		if err := watcher.Add(filepath.Dir(x)); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case err := <-watcher.Errors:
+				log.WithFields(log.Fields{"error": err}).Error("error watching the files")
+			case event := <-watcher.Events:
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				handler()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// getKubernetesConfig returns the configuration for the kubernetes api client, loaded from the
+// kubeconfig when either it or the context are set and otherwise the in-cluster configuration
+func getKubernetesConfig(config *Config) (*rest.Config, error) {
+	var kubeconfig *rest.Config
+	var err error
+
+	if config.Kubeconfig == "" && config.KubeContext == "" {
+		kubeconfig, err = rest.InClusterConfig()
+	} else {
+		kubeconfig, err = loadKubeconfig(config.Kubeconfig, config.KubeContext)
+	}
 	if err != nil {
 		return nil, err
 	}
+	setClientOptions(kubeconfig, config)
+
+	return kubeconfig, nil
+}
+
+// setClientOptions applies the rate limits and user agent from the configuration to the client
+func setClientOptions(kubeconfig *rest.Config, config *Config) {
+	if config.ClientQPS > 0 {
+		kubeconfig.QPS = float32(config.ClientQPS)
+	}
+	if config.ClientBurst > 0 {
+		kubeconfig.Burst = config.ClientBurst
+	}
+	kubeconfig.UserAgent = config.UserAgent
+	if kubeconfig.UserAgent == "" {
+		kubeconfig.UserAgent = fmt.Sprintf("ingress-admission/%s", Version)
+	}
+}
+
+// loadKubeconfig returns the client configuration from the kubeconfig and context, using the usual
+// loading rules (i.e. $KUBECONFIG or ~/.kube/config) when no path is given and falling back to
+// the in-cluster configuration when no kubeconfig is found
+func loadKubeconfig(path, context string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	// @note: $KUBECONFIG may be a list of files to merge, as with kubectl
+	if paths := filepath.SplitList(path); len(paths) > 1 {
+		rules.Precedence = paths
+	} else {
+		rules.ExplicitPath = path
+	}
 
-	return kubernetes.NewForConfig(config)
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: context,
+	}).ClientConfig()
 }
@@ -17,17 +17,112 @@ limitations under the License.
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetTLSCOnfig(t *testing.T) {
-	c, err := getTLSConfig(&Config{})
+	c, err := getTLSConfig(&Config{}, nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, c)
 }
 
+func TestGetTLSConfigClientTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := createFakeCertificate(t, "ca", nil)
+	ca.write(t, dir, "ca")
+
+	c, err := getTLSConfig(&Config{EnableClientTLS: true, TLSCA: filepath.Join(dir, "ca.pem")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, c.ClientAuth)
+	assert.NotNil(t, c.ClientCAs)
+	assert.Nil(t, c.VerifyPeerCertificate)
+
+	_, err = getTLSConfig(&Config{EnableClientTLS: true, TLSCA: filepath.Join(dir, "missing.pem")}, nil)
+	assert.Error(t, err)
+	_, err = getTLSConfig(&Config{EnableClientTLS: true, TLSCA: filepath.Join(dir, "ca-key.pem")}, nil)
+	assert.Error(t, err)
+}
+
+func TestClientTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := createFakeCertificate(t, "ca", nil)
+	ca.write(t, dir, "ca")
+	createFakeCertificate(t, "127.0.0.1", ca).write(t, dir, "tls")
+
+	certificates, err := newCertificateReloader(filepath.Join(dir, "tls.pem"), filepath.Join(dir, "tls-key.pem"))
+	require.NoError(t, err)
+	cfg, err := getTLSConfig(&Config{
+		ClientNames:     []string{"kube-apiserver"},
+		EnableClientTLS: true,
+		TLSCA:           filepath.Join(dir, "ca.pem"),
+	}, certificates)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = tls.NewListener(server.Listener, cfg)
+	server.Start()
+	defer server.Close()
+	url := "https://" + server.Listener.Addr().String()
+
+	cs := []struct {
+		Client   *fakeCertificate
+		Expected bool
+	}{
+		{},
+		{Client: createFakeCertificate(t, "kube-apiserver", ca), Expected: true},
+		{Client: createFakeCertificate(t, "someone", ca)},
+		{Client: createFakeCertificate(t, "kube-apiserver", createFakeCertificate(t, "other", nil))},
+	}
+	for i, c := range cs {
+		clientTLS := &tls.Config{RootCAs: x509.NewCertPool()}
+		clientTLS.RootCAs.AddCert(ca.cert)
+		if c.Client != nil {
+			pair, err := tls.X509KeyPair(c.Client.certPEM, c.Client.keyPEM)
+			require.NoError(t, err)
+			clientTLS.Certificates = []tls.Certificate{pair}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+		resp, err := client.Get(url)
+		if c.Expected {
+			require.NoError(t, err, "case %d should have succeeded", i)
+			resp.Body.Close()
+			continue
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		assert.Error(t, err, "case %d should have failed", i)
+	}
+}
+
+func TestVerifyClientName(t *testing.T) {
+	ca := createFakeCertificate(t, "ca", nil)
+	verify := verifyClientName([]string{"kube-apiserver", "apiserver.example.com"})
+
+	assert.NoError(t, verify(nil, [][]*x509.Certificate{{createFakeCertificate(t, "kube-apiserver", ca).cert}}))
+	assert.NoError(t, verify(nil, [][]*x509.Certificate{{createFakeCertificate(t, "apiserver.example.com", ca).cert}}))
+	assert.Error(t, verify(nil, [][]*x509.Certificate{{createFakeCertificate(t, "someone", ca).cert}}))
+	assert.Error(t, verify(nil, nil))
+}
+
 func TestHasDomainOK(t *testing.T) {
 	cs := []struct {
 		Hostname  string
@@ -65,3 +160,213 @@ func TestHasDomainBad(t *testing.T) {
 		assert.False(t, hasDomain(c.Hostname, c.Whitelist), "case %d, should have been false", i)
 	}
 }
+
+func TestHasDomain(t *testing.T) {
+	cs := []struct {
+		Hostname  string
+		Whitelist []string
+		Expected  bool
+	}{
+		{Hostname: "", Whitelist: []string{"*.example.com"}},
+		{Hostname: "", Whitelist: []string{""}},
+		{Hostname: "site.example.com", Whitelist: nil},
+		{Hostname: "site.example.com", Whitelist: []string{"", " "}},
+		{Hostname: "site.example.com", Whitelist: []string{"site.example.com"}, Expected: true},
+		{Hostname: "Site.Example.COM", Whitelist: []string{"site.example.com"}, Expected: true},
+		{Hostname: "site.example.com", Whitelist: []string{"SITE.example.com"}, Expected: true},
+		{Hostname: "site.example.com.", Whitelist: []string{"site.example.com"}, Expected: true},
+		{Hostname: "site.example.com", Whitelist: []string{"site.example.com."}, Expected: true},
+		{Hostname: "site.example.com", Whitelist: []string{" site.example.com "}, Expected: true},
+		{Hostname: "site.example.com", Whitelist: []string{"other.example.com"}},
+		{Hostname: "mysite.example.com", Whitelist: []string{"site.example.com"}},
+		// single label wildcards
+		{Hostname: "site.example.com", Whitelist: []string{"*.example.com"}, Expected: true},
+		{Hostname: "example.com", Whitelist: []string{"*.example.com"}},
+		{Hostname: "a.site.example.com", Whitelist: []string{"*.example.com"}},
+		{Hostname: "site.badexample.com", Whitelist: []string{"*.example.com"}},
+		{Hostname: "site.example.com.evil.com", Whitelist: []string{"*.example.com"}},
+		{Hostname: "*.example.com", Whitelist: []string{"*.example.com"}, Expected: true},
+		{Hostname: "*.site.example.com", Whitelist: []string{"*.example.com"}},
+		// multi label wildcards
+		{Hostname: "site.example.com", Whitelist: []string{"**.example.com"}, Expected: true},
+		{Hostname: "a.b.site.example.com", Whitelist: []string{"**.example.com"}, Expected: true},
+		{Hostname: "*.site.example.com", Whitelist: []string{"**.example.com"}, Expected: true},
+		{Hostname: "example.com", Whitelist: []string{"**.example.com"}},
+		{Hostname: "site.badexample.com", Whitelist: []string{"**.example.com"}},
+		// apex plus subdomains
+		{Hostname: "example.com", Whitelist: []string{".example.com"}, Expected: true},
+		{Hostname: "site.example.com", Whitelist: []string{".example.com"}, Expected: true},
+		{Hostname: "a.b.example.com", Whitelist: []string{".example.com"}, Expected: true},
+		{Hostname: "badexample.com", Whitelist: []string{".example.com"}},
+		// later entries are evaluated after a wildcard mismatch
+		{Hostname: "x.b.c.com", Whitelist: []string{"*.a.com", "x.b.c.com"}, Expected: true},
+		{Hostname: "x.b.c.com", Whitelist: []string{"*.a.com", "*.b.c.com"}, Expected: true},
+		{Hostname: "x.y.b.c.com", Whitelist: []string{"*.a.com", "*.b.c.com", "**.c.com"}, Expected: true},
+	}
+	for i, c := range cs {
+		assert.Equal(t, c.Expected, hasDomain(c.Hostname, c.Whitelist), "case %d, hostname: %s, whitelist: %v", i, c.Hostname, c.Whitelist)
+	}
+}
+
+func TestParseDenyDomains(t *testing.T) {
+	list, err := parseDenyDomains([]string{"*.internal.example.com", "api.example.com:platform:kube-system"})
+	assert.NoError(t, err)
+	assert.Equal(t, []DenyDomain{
+		{Domain: "*.internal.example.com"},
+		{Domain: "api.example.com", Namespaces: []string{"platform", "kube-system"}},
+	}, list)
+
+	for _, x := range []string{"", ":platform", "api.example.com:", "api.example.com::platform"} {
+		_, err := parseDenyDomains([]string{x})
+		assert.Error(t, err, "deny domain: %q should have failed", x)
+	}
+}
+
+func TestIsDeniedDomain(t *testing.T) {
+	denied := []DenyDomain{
+		{Domain: "**.internal.example.com"},
+		{Domain: "api.example.com", Namespaces: []string{"platform"}},
+	}
+	cs := []struct {
+		Hostname  string
+		Namespace string
+		Expected  bool
+	}{
+		{Hostname: "site.example.com", Namespace: "test"},
+		{Hostname: "a.internal.example.com", Namespace: "test", Expected: true},
+		{Hostname: "a.b.internal.example.com", Namespace: "platform", Expected: true},
+		{Hostname: "api.example.com", Namespace: "test", Expected: true},
+		{Hostname: "API.example.com", Namespace: "test", Expected: true},
+		{Hostname: "api.example.com", Namespace: "platform"},
+	}
+	for i, c := range cs {
+		assert.Equal(t, c.Expected, isDeniedDomain(c.Hostname, c.Namespace, denied), "case %d", i)
+	}
+}
+
+func TestGetKubernetesConfig(t *testing.T) {
+	path := writeFakeKubeconfig(t)
+
+	config, err := getKubernetesConfig(&Config{Kubeconfig: path})
+	require.NoError(t, err)
+	assert.Equal(t, "https://dev.example.com:6443", config.Host)
+	assert.Equal(t, "ingress-admission/"+Version, config.UserAgent)
+	assert.Zero(t, config.QPS)
+	assert.Zero(t, config.Burst)
+
+	config, err = getKubernetesConfig(&Config{
+		Kubeconfig:  path,
+		KubeContext: "prod",
+		ClientQPS:   20,
+		ClientBurst: 40,
+		UserAgent:   "test",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com:6443", config.Host)
+	assert.Equal(t, "test", config.UserAgent)
+	assert.Equal(t, float32(20), config.QPS)
+	assert.Equal(t, 40, config.Burst)
+
+	_, err = getKubernetesConfig(&Config{Kubeconfig: path, KubeContext: "missing"})
+	assert.Error(t, err)
+}
+
+func TestLoadKubeconfigList(t *testing.T) {
+	path := writeFakeKubeconfig(t)
+
+	config, err := loadKubeconfig(filepath.Join(t.TempDir(), "missing")+string(filepath.ListSeparator)+path, "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com:6443", config.Host)
+}
+
+// fakeCertificate is a generated certificate and key used in the tests
+type fakeCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// createFakeCertificate generates a certificate for the name signed by the parent, or a self signed
+// ca when the parent is nil
+func createFakeCertificate(t *testing.T, name string, parent *fakeCertificate) *fakeCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	encodedKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &fakeCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey}),
+	}
+}
+
+// write writes the certificate and key into the directory as NAME.pem and NAME-key.pem
+func (f *fakeCertificate) write(t *testing.T, dir, name string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".pem"), f.certPEM, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), f.keyPEM, 0600))
+}
+
+// writeFakeKubeconfig writes a kubeconfig with a dev (the current) and prod context
+func writeFakeKubeconfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: test
+- name: prod
+  context:
+    cluster: prod
+    user: test
+users:
+- name: test
+  user:
+    token: test
+`), 0600))
+
+	return path
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+	admissionregistration "k8s.io/api/admissionregistration/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// externalAdmissionHookResource is the legacy resource for registering the webhook
+var externalAdmissionHookResource = schema.GroupVersionResource{
+	Group:    "admissionregistration.k8s.io",
+	Version:  "v1alpha1",
+	Resource: "externaladmissionhookconfigurations",
+}
+
+// webhookRule is the rule the webhook is registered for
+// namespaceNameLabel is the label the apiserver sets on every namespace holding its name
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+var webhookRule = admissionregistration.RuleWithOperations{
+	Operations: []admissionregistration.OperationType{admissionregistration.Create, admissionregistration.Update},
+	Rule: admissionregistration.Rule{
+		APIGroups:   []string{"extensions", "networking.k8s.io"},
+		APIVersions: []string{"*"},
+		Resources:   []string{"ingresses"},
+	},
+}
+
+// registerWebhook creates or updates the webhook configuration, injecting the ca bundle
+func (c *controller) registerWebhook(ctx context.Context) error {
+	config := c.getConfig()
+
+	caBundle, err := c.webhookCABundle()
+	if err != nil {
+		return err
+	}
+
+	switch config.WebhookAPI {
+	case WebhookAPILegacy:
+		err = c.registerLegacyWebhook(ctx, caBundle)
+	default:
+		err = c.registerValidatingWebhook(ctx, caBundle)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"api":  config.WebhookAPI,
+		"name": AdmissionControllerName,
+	}).Info("registered the webhook configuration")
+
+	return nil
+}
+
+// deregisterWebhook removes the webhook configuration, ignoring one which does not exist
+func (c *controller) deregisterWebhook(ctx context.Context) error {
+	config := c.getConfig()
+
+	var err error
+	switch config.WebhookAPI {
+	case WebhookAPILegacy:
+		if c.dynamic == nil {
+			return errors.New("no dynamic client available for the legacy webhook api")
+		}
+		err = c.dynamic.Resource(externalAdmissionHookResource).Delete(ctx, AdmissionControllerName, metav1.DeleteOptions{})
+	default:
+		err = c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(ctx, AdmissionControllerName, metav1.DeleteOptions{})
+	}
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"api":  config.WebhookAPI,
+		"name": AdmissionControllerName,
+	}).Info("deregistered the webhook configuration")
+
+	return nil
+}
+
+// webhookCABundle returns the ca bundle used by the apiserver to verify the controller, preferring
+// the bootstrapped ca if any; note the tls-ca is deliberately not used as it verifies the client
+// certificates and need not have signed the serving certificate
+func (c *controller) webhookCABundle() ([]byte, error) {
+	if bundle := c.getCABundle(); bundle != nil {
+		return bundle, nil
+	}
+
+	path := c.getConfig().WebhookCABundle
+	if path == "" {
+		return nil, errors.New("no ca bundle available for the webhook, set the webhook-ca-bundle or enable-cert-bootstrap")
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// registerValidatingWebhook creates or updates the validating webhook configuration
+func (c *controller) registerValidatingWebhook(ctx context.Context, caBundle []byte) error {
+	config := c.getConfig()
+	client := c.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+
+	failurePolicy := admissionregistration.FailurePolicyType(config.WebhookFailurePolicy)
+	sideEffects := admissionregistration.SideEffectClassNone
+	path := "/"
+	// @note: the controller namespace is excluded so a failing controller can always be redeployed
+	namespaceSelector := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      namespaceNameLabel,
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{config.ServiceNamespace},
+			},
+		},
+	}
+
+	webhook := &admissionregistration.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: AdmissionControllerName},
+		Webhooks: []admissionregistration.ValidatingWebhook{
+			{
+				Name:                    AdmissionControllerName,
+				AdmissionReviewVersions: []string{admissionV1, admissionV1beta1},
+				SideEffects:             &sideEffects,
+				FailurePolicy:           &failurePolicy,
+				NamespaceSelector:       namespaceSelector,
+				Rules:                   []admissionregistration.RuleWithOperations{webhookRule},
+				ClientConfig: admissionregistration.WebhookClientConfig{
+					CABundle: caBundle,
+					Service: &admissionregistration.ServiceReference{
+						Name:      config.ServiceName,
+						Namespace: config.ServiceNamespace,
+						Path:      &path,
+					},
+				},
+			},
+		},
+	}
+
+	current, err := client.Get(ctx, AdmissionControllerName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = client.Create(ctx, webhook, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	webhook.ResourceVersion = current.ResourceVersion
+	_, err = client.Update(ctx, webhook, metav1.UpdateOptions{})
+
+	return err
+}
+
+// registerLegacyWebhook creates or updates the legacy external admission hook configuration
+func (c *controller) registerLegacyWebhook(ctx context.Context, caBundle []byte) error {
+	if c.dynamic == nil {
+		return errors.New("no dynamic client available for the legacy webhook api")
+	}
+	config := c.getConfig()
+	client := c.dynamic.Resource(externalAdmissionHookResource)
+
+	webhook := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": externalAdmissionHookResource.GroupVersion().String(),
+		"kind":       "ExternalAdmissionHookConfiguration",
+		"metadata": map[string]interface{}{
+			"name": AdmissionControllerName,
+		},
+		"externalAdmissionHooks": []interface{}{
+			map[string]interface{}{
+				"name": AdmissionControllerName,
+				"rules": []interface{}{
+					map[string]interface{}{
+						"apiGroups":   toInterfaceSlice(webhookRule.APIGroups),
+						"apiVersions": toInterfaceSlice(webhookRule.APIVersions),
+						"operations":  []interface{}{string(admissionregistration.Create), string(admissionregistration.Update)},
+						"resources":   toInterfaceSlice(webhookRule.Resources),
+					},
+				},
+				"failurePolicy": config.WebhookFailurePolicy,
+				"clientConfig": map[string]interface{}{
+					"service": map[string]interface{}{
+						"name":      config.ServiceName,
+						"namespace": config.ServiceNamespace,
+					},
+					// @note: byte fields are serialized as base64 strings
+					"caBundle": base64.StdEncoding.EncodeToString(caBundle),
+				},
+			},
+		},
+	}}
+
+	current, err := client.Get(ctx, AdmissionControllerName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = client.Create(ctx, webhook, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	webhook.SetResourceVersion(current.GetResourceVersion())
+	_, err = client.Update(ctx, webhook, metav1.UpdateOptions{})
+
+	return err
+}
+
+// toInterfaceSlice converts the strings for use in an unstructured object
+func toInterfaceSlice(list []string) []interface{} {
+	items := make([]interface{}, len(list))
+	for i, x := range list {
+		items[i] = x
+	}
+
+	return items
+}
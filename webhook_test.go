@@ -0,0 +1,116 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistration "k8s.io/api/admissionregistration/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestRegisterValidatingWebhook(t *testing.T) {
+	c := newFakeWebhookController(t, WebhookAPIValidating)
+	client := c.service.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+
+	require.NoError(t, c.service.registerWebhook(context.TODO()))
+	webhook, err := client.Get(context.TODO(), AdmissionControllerName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, webhook.Webhooks, 1)
+	hook := webhook.Webhooks[0]
+	assert.Equal(t, []byte("ca"), hook.ClientConfig.CABundle)
+	assert.Equal(t, "ingress-admission", hook.ClientConfig.Service.Name)
+	assert.Equal(t, "kube-admission", hook.ClientConfig.Service.Namespace)
+	assert.Equal(t, admissionregistration.Fail, *hook.FailurePolicy)
+	require.NotNil(t, hook.NamespaceSelector)
+	assert.Equal(t, []metav1.LabelSelectorRequirement{
+		{Key: namespaceNameLabel, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"kube-admission"}},
+	}, hook.NamespaceSelector.MatchExpressions)
+	assert.Equal(t, []admissionregistration.RuleWithOperations{webhookRule}, hook.Rules)
+
+	// @step: registering again should update the configuration
+	require.NoError(t, ioutil.WriteFile(c.service.config.WebhookCABundle, []byte("rotated"), 0644))
+	require.NoError(t, c.service.registerWebhook(context.TODO()))
+	webhook, err = client.Get(context.TODO(), AdmissionControllerName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("rotated"), webhook.Webhooks[0].ClientConfig.CABundle)
+
+	require.NoError(t, c.service.deregisterWebhook(context.TODO()))
+	_, err = client.Get(context.TODO(), AdmissionControllerName, metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+	assert.NoError(t, c.service.deregisterWebhook(context.TODO()))
+}
+
+func TestRegisterLegacyWebhook(t *testing.T) {
+	c := newFakeWebhookController(t, WebhookAPILegacy)
+	c.service.dynamic = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{externalAdmissionHookResource: "ExternalAdmissionHookConfigurationList"})
+	client := c.service.dynamic.Resource(externalAdmissionHookResource)
+
+	require.NoError(t, c.service.registerWebhook(context.TODO()))
+	require.NoError(t, c.service.registerWebhook(context.TODO()))
+	webhook, err := client.Get(context.TODO(), AdmissionControllerName, metav1.GetOptions{})
+	require.NoError(t, err)
+	hooks, found, err := unstructured.NestedSlice(webhook.Object, "externalAdmissionHooks")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Len(t, hooks, 1)
+	caBundle, _, _ := unstructured.NestedString(hooks[0].(map[string]interface{}), "clientConfig", "caBundle")
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("ca")), caBundle)
+
+	require.NoError(t, c.service.deregisterWebhook(context.TODO()))
+	_, err = client.Get(context.TODO(), AdmissionControllerName, metav1.GetOptions{})
+	assert.True(t, kerrors.IsNotFound(err))
+}
+
+func TestRegisterWebhookNoCABundle(t *testing.T) {
+	c := newFakeWebhookController(t, WebhookAPIValidating)
+	c.service.config.TLSCA = c.service.config.WebhookCABundle
+	c.service.config.WebhookCABundle = ""
+	assert.Error(t, c.service.registerWebhook(context.TODO()))
+}
+
+func TestRegisterLegacyWebhookNoDynamicClient(t *testing.T) {
+	c := newFakeWebhookController(t, WebhookAPILegacy)
+	assert.Error(t, c.service.registerWebhook(context.TODO()))
+	assert.Error(t, c.service.deregisterWebhook(context.TODO()))
+}
+
+func newFakeWebhookController(t *testing.T, api string) *fakeController {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(path, []byte("ca"), 0644))
+
+	c := newFakeController()
+	c.service.config.ServiceName = "ingress-admission"
+	c.service.config.ServiceNamespace = "kube-admission"
+	c.service.config.WebhookAPI = api
+	c.service.config.WebhookCABundle = path
+	c.service.config.WebhookFailurePolicy = "Fail"
+
+	return c
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+const (
+	// regexPrefix is the prefix for a regular expression whitelist entry
+	regexPrefix = "re:"
+	// globPrefix is the prefix for a glob whitelist entry
+	globPrefix = "glob:"
+	// maxPatternLength is the maximum length of a pattern we permit
+	maxPatternLength = 256
+	// minSuffixLabels is the minimum number of literal labels a pattern must end with
+	minSuffixLabels = 2
+)
+
+// domainWhitelist is a compiled namespace whitelist
+type domainWhitelist struct {
+	// domains are the plain domain entries
+	domains []string
+	// patterns are the compiled regex and glob entries
+	patterns []whitelistPattern
+}
+
+// whitelistPattern is a compiled regex or glob entry
+type whitelistPattern struct {
+	// entry is the entry as written in the whitelist
+	entry string
+	// re is the compiled expression
+	re *regexp.Regexp
+}
+
+// whitelistCache holds the compiled whitelists for the namespaces
+type whitelistCache struct {
+	sync.Mutex
+	// items is a map of namespace to compiled whitelist
+	items map[string]*cachedWhitelist
+}
+
+// cachedWhitelist is a compiled whitelist and the entries it was compiled from
+type cachedWhitelist struct {
+	entries   []string
+	whitelist *domainWhitelist
+}
+
+// newWhitelistCache creates an empty whitelist cache
+func newWhitelistCache() *whitelistCache {
+	return &whitelistCache{items: make(map[string]*cachedWhitelist)}
+}
+
+// compile returns the compiled whitelist for the namespace, reusing the previous
+// compilation until the entries in the namespace policy change
+func (w *whitelistCache) compile(namespace string, entries []string) (*domainWhitelist, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if cached, found := w.items[namespace]; found && equalEntries(cached.entries, entries) {
+		return cached.whitelist, nil
+	}
+
+	whitelist, err := parseWhitelistEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	w.items[namespace] = &cachedWhitelist{entries: append([]string(nil), entries...), whitelist: whitelist}
+
+	return whitelist, nil
+}
+
+// equalEntries checks if the two whitelists have the same entries in the same order
+func equalEntries(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// delete removes the namespace from the cache
+func (w *whitelistCache) delete(namespace string) {
+	w.Lock()
+	defer w.Unlock()
+
+	delete(w.items, namespace)
+}
+
+// splitWhitelist splits the comma separated whitelist of the namespace annotation into its entries
+func splitWhitelist(value string) []string {
+	var list []string
+	for _, x := range strings.Split(value, ",") {
+		list = append(list, strings.TrimSpace(x))
+	}
+
+	return list
+}
+
+// parseWhitelist parses the comma separated whitelist, compiling any pattern entries
+func parseWhitelist(value string) (*domainWhitelist, error) {
+	return parseWhitelistEntries(splitWhitelist(value))
+}
+
+// parseWhitelistEntries parses the whitelist entries, compiling any pattern entries; unlike the
+// annotation the entries are never split, so a pattern may contain a comma, i.e. [0-9]{1,3}
+func parseWhitelistEntries(entries []string) (*domainWhitelist, error) {
+	whitelist := &domainWhitelist{}
+
+	for _, x := range entries {
+		entry := strings.TrimSpace(x)
+		switch {
+		case strings.HasPrefix(entry, regexPrefix):
+			re, err := compileRegexPattern(strings.TrimPrefix(entry, regexPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: %q, %s", entry, err)
+			}
+			whitelist.patterns = append(whitelist.patterns, whitelistPattern{entry: entry, re: re})
+		case strings.HasPrefix(entry, globPrefix):
+			re, err := compileGlobPattern(strings.TrimPrefix(entry, globPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob: %q, %s", entry, err)
+			}
+			whitelist.patterns = append(whitelist.patterns, whitelistPattern{entry: entry, re: re})
+		default:
+			whitelist.domains = append(whitelist.domains, entry)
+		}
+	}
+
+	return whitelist, nil
+}
+
+// hasDomain checks if the hostname is permitted by the whitelist
+func (w *domainWhitelist) hasDomain(hostname string) bool {
+	_, found := w.match(hostname)
+
+	return found
+}
+
+// match returns the whitelist entry which permits the hostname
+func (w *domainWhitelist) match(hostname string) (string, bool) {
+	if entry, found := matchingDomain(hostname, w.domains); found {
+		return entry, true
+	}
+
+	hostname = normalizeDomain(hostname)
+	if hostname == "" {
+		return "", false
+	}
+	for _, x := range w.patterns {
+		if x.re.MatchString(hostname) {
+			return x.entry, true
+		}
+	}
+
+	return "", false
+}
+
+// compileRegexPattern validates and compiles a regular expression; the expression must be anchored
+// at both ends, cannot contain nested repetition, i.e. (a+)+, and must end with a literal domain
+// of at least two labels so it cannot match an arbitrary tld, i.e. ^.*$
+func compileRegexPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, errors.New("pattern is empty")
+	}
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("pattern exceeds the maximum length of %d", maxPatternLength)
+	}
+
+	tree, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	if !isAnchored(tree) {
+		return nil, errors.New("pattern must be anchored with ^ and $")
+	}
+	if hasNestedRepeat(tree, false) {
+		return nil, errors.New("pattern contains nested repetition")
+	}
+	if suffixLabels(regexLiteralSuffix(tree)) < minSuffixLabels {
+		return nil, fmt.Errorf("pattern must end with a literal domain of at least %d labels, i.e. \\.example\\.com$", minSuffixLabels)
+	}
+
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// compileGlobPattern converts a glob into a regular expression, where * matches zero or more
+// characters and ? a single character, neither of which will match across a label; as with the
+// regular expressions the glob must end with a literal domain of at least two labels
+func compileGlobPattern(pattern string) (*regexp.Regexp, error) {
+	pattern = normalizeDomain(pattern)
+	if pattern == "" {
+		return nil, errors.New("glob is empty")
+	}
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("glob exceeds the maximum length of %d", maxPatternLength)
+	}
+	if suffixLabels(pattern[strings.LastIndexAny(pattern, "*?")+1:], !strings.ContainsAny(pattern, "*?")) < minSuffixLabels {
+		return nil, fmt.Errorf("glob must end with a literal domain of at least %d labels, i.e. .example.com", minSuffixLabels)
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, `[a-z0-9-]*`, -1)
+	expr = strings.Replace(expr, `\?`, `[a-z0-9-]`, -1)
+
+	return regexp.Compile("^" + expr + "$")
+}
+
+// isAnchored checks the expression begins and ends with a text anchor
+func isAnchored(tree *syntax.Regexp) bool {
+	if tree.Op != syntax.OpConcat || len(tree.Sub) < 2 {
+		return false
+	}
+	first, last := tree.Sub[0], tree.Sub[len(tree.Sub)-1]
+
+	return (first.Op == syntax.OpBeginText || first.Op == syntax.OpBeginLine) &&
+		(last.Op == syntax.OpEndText || last.Op == syntax.OpEndLine)
+}
+
+// regexLiteralSuffix returns the literal text the anchored expression ends with, and whether the
+// whole expression is literal
+func regexLiteralSuffix(tree *syntax.Regexp) (string, bool) {
+	var suffix string
+
+	for i := len(tree.Sub) - 2; i > 0; i-- {
+		if tree.Sub[i].Op != syntax.OpLiteral {
+			return suffix, false
+		}
+		suffix = string(tree.Sub[i].Rune) + suffix
+	}
+
+	return suffix, true
+}
+
+// suffixLabels returns the number of complete labels in the literal suffix of a pattern; unless
+// the pattern is entirely literal the first label may be partial (i.e. pr-*.example.com) and is
+// not counted
+func suffixLabels(suffix string, whole bool) int {
+	if !whole {
+		i := strings.Index(suffix, ".")
+		if i < 0 {
+			return 0
+		}
+		suffix = suffix[i+1:]
+	}
+
+	count := 0
+	for _, x := range strings.Split(suffix, ".") {
+		if x != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// hasNestedRepeat checks if the expression contains a repetition within a repetition
+func hasNestedRepeat(tree *syntax.Regexp, repeated bool) bool {
+	switch tree.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		if repeated {
+			return true
+		}
+		repeated = true
+	}
+	for _, x := range tree.Sub {
+		if hasNestedRepeat(x, repeated) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2017 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWhitelist(t *testing.T) {
+	w, err := parseWhitelist("site.example.com, *.apps.example.com,re:^pr-[0-9]+\\.preview\\.example\\.com$,glob:dev-*.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, w)
+	assert.Equal(t, []string{"site.example.com", "*.apps.example.com"}, w.domains)
+	assert.Len(t, w.patterns, 2)
+}
+
+func TestParseWhitelistEntries(t *testing.T) {
+	w, err := parseWhitelistEntries([]string{"site.example.com", "re:^pr-[0-9]{1,3}\\.preview\\.example\\.com$"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"site.example.com"}, w.domains)
+	entry, found := w.match("pr-12.preview.example.com")
+	assert.True(t, found)
+	assert.Equal(t, "re:^pr-[0-9]{1,3}\\.preview\\.example\\.com$", entry)
+	assert.False(t, w.hasDomain("pr-1234.preview.example.com"))
+}
+
+func TestWhitelistMatch(t *testing.T) {
+	w, err := parseWhitelist("site.example.com, *.apps.example.com,glob:dev-*.example.com")
+	require.NoError(t, err)
+
+	cs := map[string]string{
+		"site.example.com":     "site.example.com",
+		"web.apps.example.com": "*.apps.example.com",
+		"dev-web.example.com":  "glob:dev-*.example.com",
+	}
+	for hostname, expected := range cs {
+		entry, found := w.match(hostname)
+		assert.True(t, found, "hostname: %s should have matched", hostname)
+		assert.Equal(t, expected, entry)
+	}
+
+	_, found := w.match("www.example.com")
+	assert.False(t, found)
+}
+
+func TestParseWhitelistBad(t *testing.T) {
+	cs := []string{
+		"re:",
+		"re:pr-[0-9]+.preview.example.com",
+		"re:^pr-[0-9]+.preview.example.com",
+		"re:pr-[0-9]+.preview.example.com$",
+		"re:^(a+)+$",
+		"re:^([a-z]*\\.)*example\\.com$",
+		"re:^[a-z$",
+		"glob:",
+		"site.example.com,re:^(a|b)+$x",
+		"re:^.*$",
+		"re:^[a-z0-9.-]+$",
+		"re:^.*\\.com$",
+		"re:^pr-[0-9]+\\.example.com$",
+		"re:^example\\.[a-z]+$",
+		"glob:*",
+		"glob:*.*",
+		"glob:*.com",
+		"glob:app.*",
+		"glob:www.example?.com",
+	}
+	for i, c := range cs {
+		_, err := parseWhitelist(c)
+		assert.Error(t, err, "case %d, %s should have been rejected", i, c)
+	}
+
+	_, err := parseWhitelist("re:^example\\.com$,glob:*.apps.example.com")
+	assert.NoError(t, err)
+}
+
+func TestWhitelistHasDomain(t *testing.T) {
+	w, err := parseWhitelist("site.example.com,re:^pr-[0-9]+\\.preview\\.example\\.com$,glob:dev-*.example.com,glob:app?.example.com")
+	require.NoError(t, err)
+
+	cs := []struct {
+		Hostname string
+		Expected bool
+	}{
+		{Hostname: "site.example.com", Expected: true},
+		{Hostname: "pr-1.preview.example.com", Expected: true},
+		{Hostname: "PR-123.preview.example.com.", Expected: true},
+		{Hostname: "pr-.preview.example.com"},
+		{Hostname: "pr-1a.preview.example.com"},
+		{Hostname: "x.pr-1.preview.example.com"},
+		{Hostname: "dev-.example.com", Expected: true},
+		{Hostname: "dev-team.example.com", Expected: true},
+		{Hostname: "dev-team.other.example.com"},
+		{Hostname: "app1.example.com", Expected: true},
+		{Hostname: "app12.example.com"},
+		{Hostname: ""},
+	}
+	for i, c := range cs {
+		assert.Equal(t, c.Expected, w.hasDomain(c.Hostname), "case %d, hostname: %s", i, c.Hostname)
+	}
+}
+
+func TestWhitelistCache(t *testing.T) {
+	cache := newWhitelistCache()
+
+	first, err := cache.compile("test", []string{"re:^a\\.example\\.com$"})
+	require.NoError(t, err)
+	second, err := cache.compile("test", []string{"re:^a\\.example\\.com$"})
+	require.NoError(t, err)
+	assert.True(t, first == second, "whitelist should have been cached")
+
+	third, err := cache.compile("test", []string{"re:^b\\.example\\.com$"})
+	require.NoError(t, err)
+	assert.False(t, first == third, "whitelist should have been recompiled")
+	assert.True(t, third.hasDomain("b.example.com"))
+
+	_, err = cache.compile("test", []string{"re:b"})
+	assert.Error(t, err)
+
+	cache.delete("test")
+	assert.Empty(t, cache.items)
+}